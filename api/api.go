@@ -4,6 +4,7 @@ import (
 	"context"
 	"ghproxy/config"
 	"ghproxy/middleware/nocache"
+	"ghproxy/proxy"
 
 	"github.com/WJQSERVER-STUDIO/logger"
 	"github.com/cloudwego/hertz/pkg/app"
@@ -49,6 +50,9 @@ func InitHandleRouter(cfg *config.Config, r *server.Hertz, version string) {
 		apiRouter.GET("/smartgit/status", func(ctx context.Context, c *app.RequestContext) {
 			SmartGitStatusHandler(cfg, c, ctx)
 		})
+		apiRouter.GET("/stats", func(ctx context.Context, c *app.RequestContext) {
+			StatsHandler(c, ctx)
+		})
 
 	}
 	logInfo("API router Init success")
@@ -126,3 +130,9 @@ func SmartGitStatusHandler(cfg *config.Config, c *app.RequestContext, ctx contex
 		"enabled": cfg.GitClone.Mode == "cache",
 	}))
 }
+
+func StatsHandler(c *app.RequestContext, ctx context.Context) {
+	logInfo("%s %s %s %s %s", c.ClientIP(), c.Method(), string(c.Path()), c.Request.Header.UserAgent(), c.Request.Header.GetProtocol())
+	c.Response.Header.Set("Content-Type", "application/json")
+	c.JSON(200, proxy.GlobalStats.Snapshot())
+}
@@ -6,7 +6,11 @@ import (
 	"github.com/BurntSushi/toml"
 )
 
+/*
+disabled = [] # 禁用的matcher, 如 ["gist"], 需位于所有表之前
+*/
 type Config struct {
+	Disabled  []string `toml:"disabled"`
 	Server    ServerConfig
 	Httpc     HttpcConfig
 	GitClone  GitCloneConfig
@@ -19,6 +23,9 @@ type Config struct {
 	RateLimit RateLimitConfig
 	Outbound  OutboundConfig
 	Docker    DockerConfig
+	Upstream  UpstreamConfig
+	Limits    LimitsConfig
+	Access    AccessConfig
 }
 
 /*
@@ -31,17 +38,63 @@ memLimit = 0 # MB
 H2C = true
 cors = "*" # "*"/"" -> "*" ; "nil" -> "" ;
 debug = false
+pathPrefix = "" # 子路径部署时的前缀, 如 "ghproxy"
+trustedHosts = [] # 允许用于改写链接的host, 为空时不校验
+canonicalHost = "" # host不受信任时使用的规范host
+errorFormat = "html" # "html" / "text" / "json" / "auto"(api matcher或Accept为json时返回json)
+canonicalRedirect = false # 请求路径非规范形式(省略scheme、尾部斜杠等)时以308重定向至规范路径
+
+	[server.responseHeaderPolicy]
+	allow = [] # 非空时仅转发列表内的响应头
+	deny = [] # 支持结尾 * 通配, 如 ["X-GitHub-*"]
+
+	[server.responseHeaderPolicy.matchers.api] # 可选, 按matcher覆盖全局策略
+	allow = []
+	deny = []
+
+	[server.corsPolicy] # 启用后代替cors配置项, 并响应OPTIONS预检请求
+	enabled = false
+	origins = ["*"] # 允许的来源, "*" 为全部
+	methods = ["GET", "HEAD", "OPTIONS"]
+	headers = [] # 为空时回显 Access-Control-Request-Headers
+	maxAge = 86400 # 预检结果缓存时间(秒), 0为不设置
 */
 
 type ServerConfig struct {
-	Port      int    `toml:"port"`
-	Host      string `toml:"host"`
-	NetLib    string `toml:"netlib"`
-	SizeLimit int    `toml:"sizeLimit"`
-	MemLimit  int64  `toml:"memLimit"`
-	H2C       bool   `toml:"H2C"`
-	Cors      string `toml:"cors"`
-	Debug     bool   `toml:"debug"`
+	Port                 int                        `toml:"port"`
+	Host                 string                     `toml:"host"`
+	NetLib               string                     `toml:"netlib"`
+	SizeLimit            int                        `toml:"sizeLimit"`
+	MemLimit             int64                      `toml:"memLimit"`
+	H2C                  bool                       `toml:"H2C"`
+	Cors                 string                     `toml:"cors"`
+	Debug                bool                       `toml:"debug"`
+	PathPrefix           string                     `toml:"pathPrefix"`
+	TrustedHosts         []string                   `toml:"trustedHosts"`
+	CanonicalHost        string                     `toml:"canonicalHost"`
+	ErrorFormat          string                     `toml:"errorFormat"`
+	CanonicalRedirect    bool                       `toml:"canonicalRedirect"`
+	ResponseHeaderPolicy ResponseHeaderPolicyConfig `toml:"responseHeaderPolicy"`
+	CORS                 CORSConfig                 `toml:"corsPolicy"`
+}
+
+type HeaderPolicyConfig struct {
+	Allow []string `toml:"allow"`
+	Deny  []string `toml:"deny"`
+}
+
+type ResponseHeaderPolicyConfig struct {
+	Allow    []string                      `toml:"allow"`
+	Deny     []string                      `toml:"deny"`
+	Matchers map[string]HeaderPolicyConfig `toml:"matchers"`
+}
+
+type CORSConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Origins []string `toml:"origins"`
+	Methods []string `toml:"methods"`
+	Headers []string `toml:"headers"`
+	MaxAge  int      `toml:"maxAge"`
 }
 
 /*
@@ -51,6 +104,7 @@ maxIdleConns = 100 # only for advanced mode
 maxIdleConnsPerHost = 60 # only for advanced mode
 maxConnsPerHost = 0 # only for advanced mode
 useCustomRawHeaders = false
+apiDefaultAccept = "" # api请求未携带Accept时注入的默认值, 如 "application/vnd.github+json"
 */
 type HttpcConfig struct {
 	Mode                string `toml:"mode"`
@@ -58,6 +112,7 @@ type HttpcConfig struct {
 	MaxIdleConnsPerHost int    `toml:"maxIdleConnsPerHost"`
 	MaxConnsPerHost     int    `toml:"maxConnsPerHost"`
 	UseCustomRawHeaders bool   `toml:"useCustomRawHeaders"`
+	APIDefaultAccept    string `toml:"apiDefaultAccept"`
 }
 
 /*
@@ -76,10 +131,54 @@ type GitCloneConfig struct {
 [shell]
 editor = true
 rewriteAPI = false
+enableCDNPaths = false
+omitSchemeInRewrite = false # 改写为 https://host/github.com/... 形式, 该形式经由不含Matcher校验的路由处理
+redirectMatchers = [] # 以302重定向代替代理的matcher, 如 ["releases"]
+sanitizeDisposition = false # 清理Content-Disposition文件名中的路径与控制字符
+preventDoubleProxy = false # 已指向本代理的链接不再改写, 请求中嵌套的代理前缀会被解开
+flushPerLine = false # 改写时每行刷新一次输出, 适用于SSE等流式文本
+stripQueryParams = [] # 改写时移除的查询参数, 支持结尾 * 通配, 如 ["utm_*", "ref"]
+compressIdentity = false # 上游未压缩且客户端接受gzip时, 对改写后的输出进行gzip压缩
+allowRawOverride = false # 允许以 ?ghproxy_raw=1 要求单次请求原样透传, 该参数不转发给上游
+skipRewritePatterns = [] # 路径匹配任一正则时跳过改写, 如 ["\\.min\\.(js|css)$"]
+scriptBanner = "" # 插入到shell脚本开头(shebang之后)的横幅行, 如 "# Served via ghproxy"
+replaceUpstreamErrors = false # 需改写的内容上游返回4xx/5xx时, 以代理的错误页面代替上游错误页面
+
+	[shell.contentTypeOverrides] # 按扩展名覆盖raw/blob响应的Content-Type
+	".sh" = "application/x-sh"
+
+	[shell.hostAliases] # 改写前将链接的host替换为规范host
+	"raw.github.com" = "raw.githubusercontent.com"
+
+	[shell.cacheControl] # 按ref类型设置raw/blob响应的Cache-Control
+	enabled = false
+	shaMaxAge = 31536000 # 固定commit SHA的内容不可变, 缓存时间(秒)
+	refValue = "no-cache" # 分支/标签内容使用的Cache-Control
 */
 type ShellConfig struct {
-	Editor     bool `toml:"editor"`
-	RewriteAPI bool `toml:"rewriteAPI"`
+	Editor                bool               `toml:"editor"`
+	RewriteAPI            bool               `toml:"rewriteAPI"`
+	EnableCDNPaths        bool               `toml:"enableCDNPaths"`
+	OmitSchemeInRewrite   bool               `toml:"omitSchemeInRewrite"`
+	RedirectMatchers      []string           `toml:"redirectMatchers"`
+	SanitizeDisposition   bool               `toml:"sanitizeDisposition"`
+	PreventDoubleProxy    bool               `toml:"preventDoubleProxy"`
+	FlushPerLine          bool               `toml:"flushPerLine"`
+	StripQueryParams      []string           `toml:"stripQueryParams"`
+	CompressIdentity      bool               `toml:"compressIdentity"`
+	AllowRawOverride      bool               `toml:"allowRawOverride"`
+	SkipRewritePatterns   []string           `toml:"skipRewritePatterns"`
+	ScriptBanner          string             `toml:"scriptBanner"`
+	ReplaceUpstreamErrors bool               `toml:"replaceUpstreamErrors"`
+	ContentTypeOverrides  map[string]string  `toml:"contentTypeOverrides"`
+	HostAliases           map[string]string  `toml:"hostAliases"`
+	CacheControl          CacheControlConfig `toml:"cacheControl"`
+}
+
+type CacheControlConfig struct {
+	Enabled   bool   `toml:"enabled"`
+	ShaMaxAge int    `toml:"shaMaxAge"`
+	RefValue  string `toml:"refValue"`
 }
 
 /*
@@ -99,6 +198,7 @@ type LogConfig struct {
 	MaxLogSize   int    `toml:"maxLogSize"`
 	Level        string `toml:"level"`
 	HertZLogPath string `toml:"hertzLogPath"`
+	AuditRejects bool   `toml:"auditRejects"`
 }
 
 /*
@@ -109,14 +209,18 @@ Token = "token"
 enabled = false
 passThrough = false
 ForceAllowApi = true
+allowPrivateClone = false
+apiReadOnlyWhenUnauthed = false # 未启用header鉴权时仍允许GET/HEAD的API请求
 */
 type AuthConfig struct {
-	Enabled       bool   `toml:"enabled"`
-	Method        string `toml:"method"`
-	Key           string `toml:"key"`
-	Token         string `toml:"token"`
-	PassThrough   bool   `toml:"passThrough"`
-	ForceAllowApi bool   `toml:"ForceAllowApi"`
+	Enabled                 bool   `toml:"enabled"`
+	Method                  string `toml:"method"`
+	Key                     string `toml:"key"`
+	Token                   string `toml:"token"`
+	PassThrough             bool   `toml:"passThrough"`
+	ForceAllowApi           bool   `toml:"ForceAllowApi"`
+	AllowPrivateClone       bool   `toml:"allowPrivateClone"`
+	ApiReadOnlyWhenUnauthed bool   `toml:"apiReadOnlyWhenUnauthed"`
 }
 
 type BlacklistConfig struct {
@@ -180,6 +284,69 @@ type DockerConfig struct {
 	Target  string `toml:"target"`
 }
 
+/*
+[upstream]
+allowPackages = false # 是否代理 npm.pkg.github.com / maven.pkg.github.com
+maxRedirects = 0 # 跟随上游重定向的最大次数, 超过时返回502, 0为使用默认值(10)
+defaultBranch = "" # raw/blob链接ref为HEAD或省略时替换为该分支, 为空时保持HEAD
+allowFileFinder = false # 是否代理 github.com/user/repo/find/<ref> 与 /search 文件查找器
+blockPrivateIPs = false # 拒绝连接解析到内网/回环/链路本地地址的上游, 返回502
+redirectAllowedHosts = [] # 允许跟随上游重定向的host, 支持 "*.githubusercontent.com", 其余返回502, 为空时不限制
+
+	[upstream.matcherMaxRedirects] # 可选, 按matcher覆盖
+	releases = 5
+
+	[upstream.tls] # 上游TLS设置, 适用于自签名证书的GHES等
+	caCertFile = "" # 追加信任的CA证书(PEM)路径, 启动时校验
+	insecureSkipVerify = false # 跳过证书校验, 不推荐
+*/
+type UpstreamConfig struct {
+	AllowPackages        bool              `toml:"allowPackages"`
+	MaxRedirects         int               `toml:"maxRedirects"`
+	DefaultBranch        string            `toml:"defaultBranch"`
+	AllowFileFinder      bool              `toml:"allowFileFinder"`
+	BlockPrivateIPs      bool              `toml:"blockPrivateIPs"`
+	RedirectAllowedHosts []string          `toml:"redirectAllowedHosts"`
+	MatcherMaxRedirects  map[string]int    `toml:"matcherMaxRedirects"`
+	TLS                  UpstreamTLSConfig `toml:"tls"`
+}
+
+type UpstreamTLSConfig struct {
+	CACertFile         string `toml:"caCertFile"`
+	InsecureSkipVerify bool   `toml:"insecureSkipVerify"`
+}
+
+/*
+[limits]
+bufferForLengthBytes = 0 # 改写后的响应体小于该值时完整缓冲并设置准确的Content-Length, 0为不缓冲
+maxLineBytes = 0 # 改写时单行的最大长度, 超过时分段处理, 0为不限制
+maxRequestHeaderBytes = 0 # 请求头总大小上限, 超过时返回431, 0为不限制
+maxRequestBodyBytes = 0 # 转发到上游的请求体大小上限, 超过时返回413, 0为不限制
+maxRewritesPerResponse = 0 # 单个响应改写链接的最大次数, 超过后其余链接原样输出, 0为不限制
+
+	[limits.matcherMaxRequestBodyBytes] # 可选, 按matcher覆盖, 0为不限制
+	api = 1048576
+	clone = 104857600
+*/
+type LimitsConfig struct {
+	BufferForLengthBytes       int64            `toml:"bufferForLengthBytes"`
+	MaxLineBytes               int              `toml:"maxLineBytes"`
+	MaxRequestHeaderBytes      int              `toml:"maxRequestHeaderBytes"`
+	MaxRequestBodyBytes        int64            `toml:"maxRequestBodyBytes"`
+	MaxRewritesPerResponse     int              `toml:"maxRewritesPerResponse"`
+	MatcherMaxRequestBodyBytes map[string]int64 `toml:"matcherMaxRequestBodyBytes"`
+}
+
+/*
+[access]
+
+	[access.allowedRefs] # 可选, 按 owner/repo 限制raw/blob/archive可访问的ref, 支持 "owner/*" 与 "*" 通配
+	"owner/repo" = ["main", "release"]
+*/
+type AccessConfig struct {
+	AllowedRefs map[string][]string `toml:"allowedRefs"`
+}
+
 // LoadConfig 从 TOML 配置文件加载配置
 func LoadConfig(filePath string) (*Config, error) {
 	if !FileExists(filePath) {
@@ -219,21 +386,39 @@ func FileExists(filename string) bool {
 // 默认配置结构体
 func DefaultConfig() *Config {
 	return &Config{
+		Disabled: []string{},
 		Server: ServerConfig{
-			Port:      8080,
-			Host:      "0.0.0.0",
-			NetLib:    "netpoll",
-			SizeLimit: 125,
-			MemLimit:  0,
-			H2C:       true,
-			Cors:      "*",
-			Debug:     false,
+			Port:              8080,
+			Host:              "0.0.0.0",
+			NetLib:            "netpoll",
+			SizeLimit:         125,
+			MemLimit:          0,
+			H2C:               true,
+			Cors:              "*",
+			Debug:             false,
+			PathPrefix:        "",
+			TrustedHosts:      []string{},
+			CanonicalHost:     "",
+			ErrorFormat:       "html",
+			CanonicalRedirect: false,
+			ResponseHeaderPolicy: ResponseHeaderPolicyConfig{
+				Allow: []string{},
+				Deny:  []string{},
+			},
+			CORS: CORSConfig{
+				Enabled: false,
+				Origins: []string{"*"},
+				Methods: []string{"GET", "HEAD", "OPTIONS"},
+				Headers: []string{},
+				MaxAge:  86400,
+			},
 		},
 		Httpc: HttpcConfig{
 			Mode:                "auto",
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 60,
 			MaxConnsPerHost:     0,
+			APIDefaultAccept:    "",
 		},
 		GitClone: GitCloneConfig{
 			Mode:         "bypass",
@@ -241,8 +426,27 @@ func DefaultConfig() *Config {
 			ForceH2C:     false,
 		},
 		Shell: ShellConfig{
-			Editor:     false,
-			RewriteAPI: false,
+			Editor:                false,
+			RewriteAPI:            false,
+			EnableCDNPaths:        false,
+			OmitSchemeInRewrite:   false,
+			RedirectMatchers:      []string{},
+			SanitizeDisposition:   false,
+			PreventDoubleProxy:    false,
+			FlushPerLine:          false,
+			StripQueryParams:      []string{},
+			CompressIdentity:      false,
+			AllowRawOverride:      false,
+			SkipRewritePatterns:   []string{},
+			ScriptBanner:          "",
+			ReplaceUpstreamErrors: false,
+			ContentTypeOverrides:  map[string]string{},
+			HostAliases:           map[string]string{},
+			CacheControl: CacheControlConfig{
+				Enabled:   false,
+				ShaMaxAge: 31536000,
+				RefValue:  "no-cache",
+			},
 		},
 		Pages: PagesConfig{
 			Mode:      "internal",
@@ -254,14 +458,17 @@ func DefaultConfig() *Config {
 			MaxLogSize:   10,
 			Level:        "info",
 			HertZLogPath: "/data/ghproxy/log/hertz.log",
+			AuditRejects: false,
 		},
 		Auth: AuthConfig{
-			Enabled:       false,
-			Method:        "parameters",
-			Key:           "",
-			Token:         "token",
-			PassThrough:   false,
-			ForceAllowApi: false,
+			Enabled:                 false,
+			Method:                  "parameters",
+			Key:                     "",
+			Token:                   "token",
+			PassThrough:             false,
+			ForceAllowApi:           false,
+			AllowPrivateClone:       false,
+			ApiReadOnlyWhenUnauthed: false,
 		},
 		Blacklist: BlacklistConfig{
 			Enabled:       false,
@@ -292,5 +499,29 @@ func DefaultConfig() *Config {
 			Enabled: false,
 			Target:  "ghcr",
 		},
+		Upstream: UpstreamConfig{
+			AllowPackages:        false,
+			MaxRedirects:         0,
+			DefaultBranch:        "",
+			AllowFileFinder:      false,
+			BlockPrivateIPs:      false,
+			RedirectAllowedHosts: []string{},
+			MatcherMaxRedirects:  map[string]int{},
+			TLS: UpstreamTLSConfig{
+				CACertFile:         "",
+				InsecureSkipVerify: false,
+			},
+		},
+		Limits: LimitsConfig{
+			BufferForLengthBytes:       0,
+			MaxLineBytes:               0,
+			MaxRequestHeaderBytes:      0,
+			MaxRequestBodyBytes:        0,
+			MaxRewritesPerResponse:     0,
+			MatcherMaxRequestBodyBytes: map[string]int64{},
+		},
+		Access: AccessConfig{
+			AllowedRefs: map[string][]string{},
+		},
 	}
 }
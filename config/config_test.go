@@ -0,0 +1,54 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultConfigValues(t *testing.T) {
+	cfg := DefaultConfig()
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"shell.omitSchemeInRewrite", cfg.Shell.OmitSchemeInRewrite, false},
+		{"server.responseHeaderPolicy.allow", cfg.Server.ResponseHeaderPolicy.Allow, []string{}},
+		{"server.responseHeaderPolicy.deny", cfg.Server.ResponseHeaderPolicy.Deny, []string{}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// 默认配置写出后重新加载应保持一致
+func TestDefaultConfigRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := DefaultConfig().WriteConfig(path); err != nil {
+		t.Fatalf("WriteConfig: %v", err)
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if loaded.Shell.OmitSchemeInRewrite != DefaultConfig().Shell.OmitSchemeInRewrite {
+		t.Errorf("omitSchemeInRewrite = %v after reload", loaded.Shell.OmitSchemeInRewrite)
+	}
+}
+
+// 仓库内的 config.toml 应与默认配置一致
+func TestBundledConfigMatchesDefaults(t *testing.T) {
+	loaded, err := LoadConfig("config.toml")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if loaded.Shell.OmitSchemeInRewrite != DefaultConfig().Shell.OmitSchemeInRewrite {
+		t.Errorf("config.toml omitSchemeInRewrite = %v, want %v", loaded.Shell.OmitSchemeInRewrite, DefaultConfig().Shell.OmitSchemeInRewrite)
+	}
+	if deny := loaded.Server.ResponseHeaderPolicy.Deny; len(deny) != len(DefaultConfig().Server.ResponseHeaderPolicy.Deny) {
+		t.Errorf("config.toml responseHeaderPolicy.deny = %v, want %v", deny, DefaultConfig().Server.ResponseHeaderPolicy.Deny)
+	}
+}
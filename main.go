@@ -357,6 +357,7 @@ func init() {
 		setMemLimit(cfg)
 		loadlist(cfg)
 		setupRateLimit(cfg)
+		proxy.SetErrorFormat(cfg.Server.ErrorFormat)
 
 		if cfg.Server.Debug {
 			runMode = "dev"
@@ -387,12 +388,14 @@ func main() {
 			r = server.New(
 				server.WithH2C(true),
 				server.WithHostPorts(addr),
+				server.WithStreamBody(true), // git v2 协商等大请求体不受默认大小限制
 				server.WithTransport(standard.NewTransporter),
 			)
 			r.AddProtocol("h2", factory.NewServerFactory())
 		} else {
 			r = server.New(
 				server.WithHostPorts(addr),
+				server.WithStreamBody(true),
 				server.WithTransport(standard.NewTransporter),
 			)
 		}
@@ -401,12 +404,14 @@ func main() {
 			r = server.New(
 				server.WithH2C(true),
 				server.WithHostPorts(addr),
+				server.WithStreamBody(true),
 				server.WithSenseClientDisconnection(true),
 			)
 			r.AddProtocol("h2", factory.NewServerFactory())
 		} else {
 			r = server.New(
 				server.WithHostPorts(addr),
+				server.WithStreamBody(true),
 				server.WithSenseClientDisconnection(true),
 			)
 		}
@@ -449,6 +454,11 @@ func main() {
 		c.Set("matcher", "clone")
 		proxy.RoutingHandler(cfg, limiter, iplimiter)(ctx, c)
 	})
+	// protocol v2 的 ls-refs/fetch 命令通过POST携带在请求体中
+	r.POST("/github.com/:user/:repo/git-upload-pack", func(ctx context.Context, c *app.RequestContext) {
+		c.Set("matcher", "clone")
+		proxy.RoutingHandler(cfg, limiter, iplimiter)(ctx, c)
+	})
 
 	r.GET("/raw.githubusercontent.com/:user/:repo/*filepath", func(ctx context.Context, c *app.RequestContext) {
 		c.Set("matcher", "raw")
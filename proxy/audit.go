@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"encoding/json"
+	"ghproxy/config"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// AuditRecord 已匹配到host但被Matcher或策略检查拒绝的请求记录
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	IP     string    `json:"ip"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	User   string    `json:"user,omitempty"`
+	Repo   string    `json:"repo,omitempty"`
+	Status int       `json:"status"`
+	Reason string    `json:"reason"`
+}
+
+// AuditFunc 接收审计记录, 可用于写入独立的审计日志或上报
+type AuditFunc func(record AuditRecord)
+
+// 默认以json单行写入日志
+func defaultAuditFunc(record AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	logWarning("Audit-Reject %s", data)
+}
+
+var auditFunc AuditFunc = defaultAuditFunc
+
+// SetAuditFunc 注册自定义审计处理, 传入nil恢复默认实现
+func SetAuditFunc(f AuditFunc) {
+	if f == nil {
+		f = defaultAuditFunc
+	}
+	auditFunc = f
+}
+
+// auditReject 记录Matcher及禁用/ref/鉴权检查返回的拒绝(400/403/404), 其余错误不属于审计范围
+// result 为已匹配的(部分)结果, 可为nil
+func auditReject(cfg *config.Config, c *app.RequestContext, rawPath string, result *MatchResult, errInfo *GHProxyErrors) {
+	if !cfg.Log.AuditRejects || errInfo == nil {
+		return
+	}
+	switch errInfo.StatusCode {
+	case 400, 403, 404:
+	default:
+		return
+	}
+	record := AuditRecord{
+		Time:   time.Now(),
+		IP:     c.ClientIP(),
+		Method: string(c.Method()),
+		Path:   rawPath,
+		Status: errInfo.StatusCode,
+		Reason: errInfo.ErrorMessage,
+	}
+	if result != nil {
+		record.User = result.User
+		record.Repo = result.Repo
+	}
+	auditFunc(record)
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"ghproxy/config"
+	"testing"
+)
+
+func TestAuditReject(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		disabled   bool // 不开启 log.auditRejects
+		setup      func(cfg *config.Config)
+		wantRecord bool
+		wantStatus int
+		wantUser   string
+		wantRepo   string
+	}{
+		{name: "sensitive subpath", path: "/https://github.com/user/repo/settings", wantRecord: true, wantStatus: 403, wantUser: "user", wantRepo: "repo"},
+		{name: "control characters", path: "/https://github.com/user/repo/raw/main/a%00.sh", wantRecord: true, wantStatus: 400},
+		{name: "unsupported github path", path: "/https://github.com/user/repo/pulls", wantRecord: true, wantStatus: 400, wantUser: "user", wantRepo: "repo"},
+		{name: "api without auth header", path: "/https://api.github.com/repos/o/r/contents/a.sh", wantRecord: true, wantStatus: 403, wantUser: "o", wantRepo: "r"},
+		{name: "matcher disabled", path: "/https://github.com/user/repo/raw/main/a.sh", setup: func(cfg *config.Config) { cfg.Disabled = []string{"raw"} }, wantRecord: true, wantStatus: 403, wantUser: "user", wantRepo: "repo"},
+		{name: "ref blocked", path: "/https://github.com/user/repo/raw/dev/a.sh", setup: func(cfg *config.Config) { cfg.Access.AllowedRefs = map[string][]string{"*": {"main"}} }, wantRecord: true, wantStatus: 403, wantUser: "user", wantRepo: "repo"},
+		{name: "audit disabled", path: "/https://github.com/user/repo/settings", disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []AuditRecord
+			SetAuditFunc(func(record AuditRecord) { records = append(records, record) })
+			t.Cleanup(func() { SetAuditFunc(nil) })
+			cfg := proxyTestConfig()
+			cfg.Log.AuditRejects = !tt.disabled
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			c := newTestRequestContext("GET")
+			c.Request.SetRequestURI(tt.path)
+
+			NoRouteHandler(cfg, nil, nil)(context.Background(), c)
+
+			if !tt.wantRecord {
+				if len(records) != 0 {
+					t.Fatalf("unexpected audit records: %+v", records)
+				}
+				return
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d audit records, want 1 (status %d, body %q)", len(records), c.Response.StatusCode(), c.Response.Body())
+			}
+			record := records[0]
+			if record.Status != tt.wantStatus || c.Response.StatusCode() != tt.wantStatus {
+				t.Errorf("record status = %d, response status = %d, want %d", record.Status, c.Response.StatusCode(), tt.wantStatus)
+			}
+			if record.User != tt.wantUser || record.Repo != tt.wantRepo {
+				t.Errorf("record user/repo = %q/%q, want %q/%q", record.User, record.Repo, tt.wantUser, tt.wantRepo)
+			}
+			if record.Method != "GET" || record.Reason == "" || record.Time.IsZero() {
+				t.Errorf("incomplete record: %+v", record)
+			}
+		})
+	}
+}
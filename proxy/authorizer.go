@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"fmt"
+	"ghproxy/auth"
+	"ghproxy/config"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// Authorizer 鉴权接口, 在Matcher完成分类后由handler调用
+// 返回nil表示放行, 否则返回对应的错误信息
+// 使用 *app.RequestContext 而非 *http.Request: auth 包依赖其Query与ClientIP,
+// 且 hertz adaptor 转换为 *http.Request 时会将请求体完整读入内存, 破坏上传类请求的流式转发
+type Authorizer interface {
+	Authorize(result *MatchResult, c *app.RequestContext) *GHProxyErrors
+}
+
+// DefaultAuthorizer 默认鉴权实现, 沿用header/parameters鉴权与ForceAllowApi逻辑
+type DefaultAuthorizer struct {
+	Cfg *config.Config
+}
+
+func (a *DefaultAuthorizer) Authorize(result *MatchResult, c *app.RequestContext) *GHProxyErrors {
+	cfg := a.Cfg
+
+	if result.Matcher == MatcherAPI && !cfg.Auth.ForceAllowApi {
+		if cfg.Auth.Method != "header" || !cfg.Auth.Enabled {
+			// 只读模式下放行GET/HEAD, 写操作仍然拒绝
+			method := string(c.Method())
+			if !cfg.Auth.ApiReadOnlyWhenUnauthed || (method != "GET" && method != "HEAD") {
+				return NewErrorWithStatusLookup(403, "Github API Req without AuthHeader is Not Allowed")
+			}
+		}
+	}
+
+	if cfg.Auth.Enabled {
+		authcheck, err := auth.AuthHandler(c, cfg)
+		if !authcheck {
+			return NewErrorWithStatusLookup(401, fmt.Sprintf("Unauthorized: %v", err))
+		}
+	}
+
+	return nil
+}
+
+var customAuthorizer Authorizer
+
+// SetAuthorizer 注册自定义鉴权实现(JWT/OIDC等), 传入nil恢复默认实现
+func SetAuthorizer(a Authorizer) {
+	customAuthorizer = a
+}
+
+func getAuthorizer(cfg *config.Config) Authorizer {
+	if customAuthorizer != nil {
+		return customAuthorizer
+	}
+	return &DefaultAuthorizer{Cfg: cfg}
+}
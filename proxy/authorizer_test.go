@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"ghproxy/config"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+func TestDefaultAuthorizer(t *testing.T) {
+	tests := []struct {
+		name       string
+		matcher    MatcherType
+		method     string
+		setup      func(cfg *config.Config, c *app.RequestContext)
+		wantStatus int // 0 表示放行
+	}{
+		{name: "raw without auth", matcher: MatcherRaw},
+		{name: "api without auth header", matcher: MatcherAPI, wantStatus: 403},
+		{name: "api force allowed", matcher: MatcherAPI, setup: func(cfg *config.Config, _ *app.RequestContext) {
+			cfg.Auth.ForceAllowApi = true
+		}},
+		{name: "api with header auth", matcher: MatcherAPI, setup: func(cfg *config.Config, c *app.RequestContext) {
+			cfg.Auth.Enabled = true
+			cfg.Auth.Method = "header"
+			c.Request.Header.Set("GH-Auth", "token")
+		}},
+		{name: "api with wrong token", matcher: MatcherAPI, setup: func(cfg *config.Config, c *app.RequestContext) {
+			cfg.Auth.Enabled = true
+			cfg.Auth.Method = "header"
+			c.Request.Header.Set("GH-Auth", "wrong")
+		}, wantStatus: 401},
+		{name: "raw with missing token", matcher: MatcherRaw, setup: func(cfg *config.Config, _ *app.RequestContext) {
+			cfg.Auth.Enabled = true
+			cfg.Auth.Method = "header"
+		}, wantStatus: 401},
+		// 未鉴权时api只读放行
+		{name: "read-only api GET", matcher: MatcherAPI, setup: func(cfg *config.Config, _ *app.RequestContext) {
+			cfg.Auth.ApiReadOnlyWhenUnauthed = true
+		}},
+		{name: "read-only api HEAD", matcher: MatcherAPI, method: "HEAD", setup: func(cfg *config.Config, _ *app.RequestContext) {
+			cfg.Auth.ApiReadOnlyWhenUnauthed = true
+		}},
+		{name: "read-only api POST", matcher: MatcherAPI, method: "POST", setup: func(cfg *config.Config, _ *app.RequestContext) {
+			cfg.Auth.ApiReadOnlyWhenUnauthed = true
+		}, wantStatus: 403},
+		{name: "read-only api DELETE", matcher: MatcherAPI, method: "DELETE", setup: func(cfg *config.Config, _ *app.RequestContext) {
+			cfg.Auth.ApiReadOnlyWhenUnauthed = true
+		}, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			c := newTestRequestContext(method)
+			if tt.setup != nil {
+				tt.setup(cfg, c)
+			}
+			errInfo := (&DefaultAuthorizer{Cfg: cfg}).Authorize(&MatchResult{Matcher: tt.matcher}, c)
+			if tt.wantStatus == 0 {
+				if errInfo != nil {
+					t.Fatalf("Authorize = %d %s, want allowed", errInfo.StatusCode, errInfo.ErrorMessage)
+				}
+				return
+			}
+			if errInfo == nil || errInfo.StatusCode != tt.wantStatus {
+				t.Fatalf("Authorize = %v, want status %d", errInfo, tt.wantStatus)
+			}
+		})
+	}
+}
+
+type denyAuthorizer struct {
+	calls []MatcherType
+}
+
+func (a *denyAuthorizer) Authorize(result *MatchResult, c *app.RequestContext) *GHProxyErrors {
+	a.calls = append(a.calls, result.Matcher)
+	return NewErrorWithStatusLookup(401, "custom authorizer")
+}
+
+func TestSetAuthorizer(t *testing.T) {
+	authorizer := &denyAuthorizer{}
+	SetAuthorizer(authorizer)
+	t.Cleanup(func() { SetAuthorizer(nil) })
+	captured := stopAtValidator(t)
+	c := newTestRequestContext("GET")
+	c.Request.SetRequestURI("/https://github.com/user/repo/raw/main/a.sh")
+
+	NoRouteHandler(proxyTestConfig(), nil, nil)(context.Background(), c)
+
+	if status := c.Response.StatusCode(); status != 401 {
+		t.Fatalf("status = %d, want 401 (body %q)", status, c.Response.Body())
+	}
+	if len(authorizer.calls) != 1 || authorizer.calls[0] != MatcherRaw {
+		t.Errorf("authorizer calls = %v, want [raw]", authorizer.calls)
+	}
+	if *captured != nil {
+		t.Error("request passed the custom authorizer")
+	}
+
+	SetAuthorizer(nil)
+	if _, ok := getAuthorizer(config.DefaultConfig()).(*DefaultAuthorizer); !ok {
+		t.Error("SetAuthorizer(nil) did not restore the default authorizer")
+	}
+}
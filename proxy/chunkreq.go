@@ -1,18 +1,20 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"ghproxy/config"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/WJQSERVER-STUDIO/go-utils/limitreader"
 	"github.com/cloudwego/hertz/pkg/app"
 )
 
-func ChunkedProxyRequest(ctx context.Context, c *app.RequestContext, u string, cfg *config.Config, matcher string) {
+func ChunkedProxyRequest(ctx context.Context, c *app.RequestContext, u string, cfg *config.Config, matcher MatcherType) {
 
 	var (
 		req  *http.Request
@@ -30,10 +32,18 @@ func ChunkedProxyRequest(ctx context.Context, c *app.RequestContext, u string, c
 		}
 	}()
 
+	// ?ghproxy_raw=1 要求本次请求原样透传, 参数不转发给上游
+	var rawOverride bool
+	if cfg.Shell.AllowRawOverride {
+		u, rawOverride = popRawOverride(u)
+	}
+
 	rb := client.NewRequestBuilder(string(c.Request.Method()), u)
 	rb.NoDefaultHeaders()
 	rb.SetBody(c.Request.BodyStream())
-	rb.WithContext(ctx)
+	// github.com /raw/ 至 raw.githubusercontent.com 的跳转由client在服务端跟随, 客户端始终经由代理获取内容
+	upstreamCtx, upstreamErr := withUpstreamErrHolder(withRedirectLimit(ctx, redirectLimitFor(matcher, cfg)), c)
+	rb.WithContext(upstreamCtx)
 
 	req, err = rb.Build()
 	if err != nil {
@@ -43,19 +53,68 @@ func ChunkedProxyRequest(ctx context.Context, c *app.RequestContext, u string, c
 
 	setRequestHeaders(c, req, cfg, matcher)
 	AuthPassThrough(c, cfg, req)
+	// 文件查找器仅在请求json时返回数据, 否则为html页面
+	if matcher == MatcherFind {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	// 是否需要改写响应体, 改写会改变body长度
+	// release页面懒加载的 expanded_assets 片段与tree目录、blame页面为html, 其中的链接同样需要改写
+	htmlFragment := (matcher == MatcherReleases && isExpandedAssets(u)) || matcher == MatcherTree || matcher == MatcherBlame
+	// 文件查找器的json中链接以字符串形式出现, 同样按行改写
+	// api响应不在此列, 始终原样透传, 如 git/blobs 中base64编码的 content 字段不会被改动
+	shouldRewrite := ((MatcherShell(u) && matcher.In(matchedMatchers)) || htmlFragment || matcher == MatcherFind) && cfg.Shell.Editor
+	// Range请求、原样透传覆盖与匹配skipRewritePatterns的路径不进行改写与gzip重编码
+	if rawOverride || skipRewrite(u) {
+		shouldRewrite = false
+	}
+	if req.Header.Get("Range") != "" {
+		shouldRewrite = false
+		req.Header.Del("Accept-Encoding")
+	}
+	if shouldRewrite {
+		// 改写仅支持gzip与identity: 客户端接受gzip时向上游请求gzip并原样以gzip输出,
+		// 否则不设置 Accept-Encoding, 由 Transport 透明解压, 以identity返回给客户端
+		if acceptsGzip(c) {
+			req.Header.Set("Accept-Encoding", "gzip")
+		} else {
+			req.Header.Del("Accept-Encoding")
+		}
+	}
 
 	resp, err = client.Do(req)
 	if err != nil {
+		err = upstreamErr.resolve(err)
+		if status, ok := upstreamErrorStatus(err); ok {
+			ErrorPage(c, NewErrorWithStatusLookup(status, err.Error()))
+			return
+		}
 		HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
 		return
 	}
 
+	// 记录api响应的速率限制信息, 供统计接口监控token余量
+	if matcher == MatcherAPI {
+		if info, ok := parseRateLimitHeaders(resp.Header); ok {
+			GlobalStats.SetAPIRateLimit(info)
+		}
+	}
+
 	// 错误处理(404)
 	if resp.StatusCode == 404 {
 		ErrorPage(c, NewErrorWithStatusLookup(404, "Page Not Found (From Github)"))
 		return
 	}
 
+	// 需改写的内容上游返回错误时, 按配置以代理自身的错误页面代替上游错误页面, 保留状态码
+	if cfg.Shell.ReplaceUpstreamErrors && shouldRewrite && resp.StatusCode >= 400 {
+		if err := resp.Body.Close(); err != nil {
+			logError("Failed to close response body: %v", err)
+		}
+		ErrorPage(c, NewErrorWithStatusLookup(resp.StatusCode, fmt.Sprintf("Upstream returned %d (From Github)", resp.StatusCode)))
+		return
+	}
+
 	var (
 		bodySize      int
 		contentLength string
@@ -85,52 +144,114 @@ func ChunkedProxyRequest(ctx context.Context, c *app.RequestContext, u string, c
 	// 复制响应头，排除需要移除的 header
 	for key, values := range resp.Header {
 		if _, shouldRemove := respHeadersToRemove[key]; !shouldRemove {
+			// 改写后长度未知, 不转发上游的 Content-Length
+			if shouldRewrite && key == "Content-Length" {
+				continue
+			}
+			if !responseHeaderAllowed(cfg, matcher, key) {
+				continue
+			}
 			for _, value := range values {
 				c.Header(key, value)
 			}
 		}
 	}
 
-	switch cfg.Server.Cors {
-	case "*":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "nil":
-		c.Header("Access-Control-Allow-Origin", "")
-	default:
-		c.Header("Access-Control-Allow-Origin", cfg.Server.Cors)
+	if matcher == MatcherRaw || matcher == MatcherBlob {
+		if contentType, ok := contentTypeOverride(u, cfg); ok {
+			c.Header("Content-Type", contentType)
+		}
+		if cfg.Shell.CacheControl.Enabled && resp.StatusCode == 200 {
+			c.Header("Cache-Control", cacheControlFor(c.GetString("ref"), cfg))
+		}
+	}
+
+	if cfg.Shell.SanitizeDisposition {
+		if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+			c.Header("Content-Disposition", sanitizeDisposition(disposition))
+		}
 	}
 
+	setCORSHeaders(c, cfg)
+
 	c.Status(resp.StatusCode)
 
+	// 304 没有响应体, 直接返回, 不进入改写流程
+	if resp.StatusCode == http.StatusNotModified {
+		if err := resp.Body.Close(); err != nil {
+			logError("Failed to close response body: %v", err)
+		}
+		return
+	}
+
 	bodyReader := resp.Body
 
 	if cfg.RateLimit.BandwidthLimit.Enabled {
 		bodyReader = limitreader.NewRateLimitedReader(bodyReader, bandwidthLimit, int(bandwidthBurst), ctx)
 	}
 
-	if MatcherShell(u) && matchString(matcher, matchedMatchers) && cfg.Shell.Editor {
+	// LFS batch响应: 按json结构改写对象下载地址, 文件锁api原样透传
+	if matcher == MatcherLFS && !rawOverride && !isLFSLocksURL(u) && isLFSJSON(resp.Header.Get("Content-Type")) {
+		body, err := processLFSBatch(bodyReader, resp.Header.Get("Content-Encoding"), string(c.Request.Host()), cfg)
+		if closeErr := bodyReader.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+		if err != nil {
+			HandleError(c, fmt.Sprintf("Failed to process lfs batch response: %v", err))
+			return
+		}
+		c.Response.Header.Del("Content-Encoding")
+		c.SetBodyStream(bytes.NewReader(body), len(body))
+		return
+	}
+
+	if shouldRewrite {
 		// 判断body是不是gzip
 		var compress string
 		if resp.Header.Get("Content-Encoding") == "gzip" {
 			compress = "gzip"
 		}
+		// 上游为identity时, 按配置对改写后的输出进行gzip压缩
+		outCompress := compress
+		if compress == "" && cfg.Shell.CompressIdentity && acceptsGzip(c) {
+			outCompress = "gzip"
+			c.Header("Content-Encoding", "gzip")
+			addVary(c, "Accept-Encoding")
+		}
 
 		logDebug("Use Shell Editor: %s %s %s %s %s", c.ClientIP(), c.Request.Method(), u, c.Request.Header.Get("User-Agent"), c.Request.Header.GetProtocol())
-		c.Header("Content-Length", "")
+		// 确保不会声明错误的长度, 使用chunked传输
+		c.Response.Header.Del("Content-Length")
 
 		var reader io.Reader
 
-		reader, _, err = processLinks(bodyReader, compress, string(c.Request.Host()), cfg)
-		c.SetBodyStream(reader, -1)
+		// 仅为shell脚本插入横幅
+		var banner string
+		if MatcherShell(u) {
+			banner = cfg.Shell.ScriptBanner
+		}
+
+		reader, _, err = processLinks(bodyReader, compress, outCompress, string(c.Request.Host()), cfg, htmlFragment, banner, progressFunc)
+		if err == nil && cfg.Limits.BufferForLengthBytes > 0 {
+			// 小响应体完整缓冲, 以便设置准确的 Content-Length
+			var buffered []byte
+			var complete bool
+			buffered, complete, err = bufferForLength(reader, cfg.Limits.BufferForLengthBytes)
+			if err == nil && complete {
+				c.SetBodyStream(bytes.NewReader(buffered), len(buffered))
+				return
+			}
+			reader = io.MultiReader(bytes.NewReader(buffered), reader)
+		}
+		c.SetBodyStream(newStreamGauge(reader, GlobalStats, string(matcher)), -1)
 		if err != nil {
 			logError("%s %s %s %s %s Failed to copy response body: %v", c.ClientIP(), c.Request.Method(), u, c.Request.Header.Get("User-Agent"), c.Request.Header.GetProtocol(), err)
 			ErrorPage(c, NewErrorWithStatusLookup(500, fmt.Sprintf("Failed to copy response body: %v", err)))
 			return
 		}
 	} else {
-
+		bodyReader = newStreamGauge(newStatsReader(bodyReader, GlobalStats), GlobalStats, string(matcher))
+		// 透传时保留上游 Content-Length; 上游为chunked时同样以chunked转发
 		if contentLength != "" {
 			c.SetBodyStream(bodyReader, bodySize)
 			return
@@ -139,3 +260,46 @@ func ChunkedProxyRequest(ctx context.Context, c *app.RequestContext, u string, c
 	}
 
 }
+
+// acceptsGzip 判断客户端 Accept-Encoding 是否接受gzip(q=0视为不接受)
+func acceptsGzip(c *app.RequestContext) bool {
+	for _, part := range strings.Split(string(c.Request.Header.Peek("Accept-Encoding")), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(strings.TrimSpace(q), 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// addVary 向 Vary 响应头追加字段, 已存在时不重复添加
+func addVary(c *app.RequestContext, field string) {
+	vary := string(c.Response.Header.Peek("Vary"))
+	for _, existing := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(existing), field) || strings.TrimSpace(existing) == "*" {
+			return
+		}
+	}
+	if vary != "" {
+		field = vary + ", " + field
+	}
+	c.Header("Vary", field)
+}
+
+// bufferForLength 最多读取 limit 字节, 若在此之前读完则 complete 为 true
+// 否则返回已读取的部分, 由调用方与剩余部分拼接后以chunked传输
+func bufferForLength(reader io.Reader, limit int64) (buffered []byte, complete bool, err error) {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), n <= limit, nil
+}
@@ -0,0 +1,499 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ghproxy/config"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// 改写的脚本响应按客户端的 Accept-Encoding 输出, 不接受gzip的客户端获得解压后的内容
+// 上游以两个gzip member返回, 链接跨越member边界
+func TestChunkedProxyRewriteEncoding(t *testing.T) {
+	const script = "curl -fsSL https://github.com/user/repo/raw/main/install.sh\n"
+	members := gzipMembers(t, script[:30], script[30:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(script))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(members)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{name: "client without Accept-Encoding", acceptEncoding: "", wantGzip: false},
+		{name: "client accepting gzip", acceptEncoding: "gzip, deflate", wantGzip: true},
+		{name: "client accepting only br", acceptEncoding: "br", wantGzip: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.Editor = true
+			c := newTestRequestContext(http.MethodGet)
+			c.Request.SetHost("proxy.example")
+			if tt.acceptEncoding != "" {
+				c.Request.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			if status := doChunkedProxy(t, cfg, c, server.URL+"/install.sh", MatcherRaw); status != 200 {
+				t.Fatalf("status = %d, want 200", status)
+			}
+			body := c.Response.Body()
+			gotGzip := string(c.Response.Header.Peek("Content-Encoding")) == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("Content-Encoding gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			if gotGzip {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				if body, err = io.ReadAll(gz); err != nil {
+					t.Fatalf("read gzip body: %v", err)
+				}
+			}
+			if want := "curl -fsSL https://proxy.example/https://github.com/user/repo/raw/main/install.sh\n"; string(body) != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+// 签名对象存储链接的query需原样转发到上游
+func TestChunkedProxyPreservesSignedQuery(t *testing.T) {
+	const query = "X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIA%2F20240101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20240101T000000Z&X-Amz-Expires=300&X-Amz-Signature=0123abcd&X-Amz-SignedHeaders=host&response-content-disposition=attachment%3B%20filename%3Dapp.zip"
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("binary"))
+	}))
+	defer server.Close()
+
+	c := newTestRequestContext(http.MethodGet)
+	c.Request.SetHost("proxy.example")
+	u := server.URL + "/github-production-release-asset-2e65be/1/2?" + query
+	if status := doChunkedProxy(t, proxyTestConfig(), c, u, MatcherObject); status != 200 {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if gotQuery != query {
+		t.Errorf("upstream query = %q, want %q", gotQuery, query)
+	}
+	if string(c.Response.Body()) != "binary" {
+		t.Errorf("body = %q, want upstream body unchanged", c.Response.Body())
+	}
+}
+
+// LFS 文件锁api原样透传, 请求体与凭据转发到上游
+func TestChunkedProxyLFSLocks(t *testing.T) {
+	const locks = `{"locks":[{"id":"1","path":"big.bin","owner":{"name":"user"},"locked_at":"2024-01-01T00:00:00Z"}],"next_cursor":"https://github.com/user/repo.git/info/lfs/locks?cursor=2"}`
+	const created = `{"lock":{"id":"2","path":"a.bin","owner":{"name":"user"},"locked_at":"2024-01-01T00:00:00Z"}}`
+	type upstreamRequest struct {
+		method, path, auth, body string
+	}
+	var got upstreamRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = upstreamRequest{method: r.Method, path: r.URL.Path, auth: r.Header.Get("Authorization"), body: string(body)}
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(created))
+			return
+		}
+		w.Write([]byte(locks))
+	}))
+	defer server.Close()
+
+	const auth = "Basic dXNlcjpwYXNz"
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "list locks", method: http.MethodGet, path: "/user/repo.git/info/lfs/locks", wantStatus: 200, wantBody: locks},
+		{name: "create lock", method: http.MethodPost, path: "/user/repo.git/info/lfs/locks", body: `{"path":"a.bin"}`, wantStatus: 201, wantBody: created},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = upstreamRequest{}
+			cfg := proxyTestConfig()
+			cfg.Auth.AllowPrivateClone = true
+			c := newTestRequestContext(tt.method)
+			c.Request.SetHost("proxy.example")
+			c.Request.Header.Set("Authorization", auth)
+			if tt.body != "" {
+				c.Request.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+				// 服务端启用 StreamBody, 请求体以流的形式交给handler
+				c.Request.SetBodyStream(strings.NewReader(tt.body), len(tt.body))
+			}
+
+			if status := doChunkedProxy(t, cfg, c, server.URL+tt.path, MatcherLFS); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if want := (upstreamRequest{method: tt.method, path: tt.path, auth: auth, body: tt.body}); got != want {
+				t.Errorf("upstream request = %+v, want %+v", got, want)
+			}
+			if string(c.Response.Body()) != tt.wantBody {
+				t.Errorf("body = %q, want byte-exact %q", c.Response.Body(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// api 响应原样透传, git/blobs 中base64编码的 content 不被改动
+func TestChunkedProxyAPIBlobUnchanged(t *testing.T) {
+	const blob = `{"sha":"3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15","size":62,` +
+		`"url":"https://api.github.com/repos/user/repo/git/blobs/3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15",` +
+		`"content":"Y3VybCAtZnNTTCBodHRwczovL2dpdGh1Yi5jb20vdXNlci9yZXBvL3Jh\ndy9tYWluL2luc3RhbGwuc2gK\n","encoding":"base64"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(blob))
+	}))
+	defer server.Close()
+
+	cfg := proxyTestConfig()
+	cfg.Shell.Editor = true
+	c := newTestRequestContext(http.MethodGet)
+	c.Request.SetHost("proxy.example")
+	if status := doChunkedProxy(t, cfg, c, server.URL+"/repos/user/repo/git/blobs/3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15", MatcherAPI); status != 200 {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if string(c.Response.Body()) != blob {
+		t.Errorf("body = %q, want unchanged %q", c.Response.Body(), blob)
+	}
+}
+
+// 改写相关选项: Range请求透传等
+func TestChunkedProxyRewriteOptions(t *testing.T) {
+	const script = "curl -fsSL https://github.com/user/repo/raw/main/install.sh\n"
+	const rewritten = "curl -fsSL https://proxy.example/https://github.com/user/repo/raw/main/install.sh\n"
+	var upstreamAcceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAcceptEncoding = r.Header.Get("Accept-Encoding")
+		if r.URL.Path == "/error.sh" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(script))
+			return
+		}
+		http.ServeContent(w, r, "install.sh", time.Time{}, strings.NewReader(script))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name                   string
+		setup                  func(cfg *config.Config, c *app.RequestContext)
+		path                   string
+		wantStatus             int
+		wantGzip               bool
+		wantBody               string
+		wantBodyContains       string // 非空时仅检查body包含该内容
+		wantUpstreamNoEncoding bool   // 期望上游未收到 Accept-Encoding
+	}{
+		{
+			name:       "rewrite",
+			wantStatus: 200,
+			wantBody:   rewritten,
+		},
+		{
+			name: "range passes through",
+			setup: func(_ *config.Config, c *app.RequestContext) {
+				c.Request.Header.Set("Accept-Encoding", "gzip")
+				c.Request.Header.Set("Range", "bytes=0-9")
+			},
+			wantStatus:             206,
+			wantBody:               script[:10],
+			wantUpstreamNoEncoding: true,
+		},
+		{
+			name: "compress identity for gzip client",
+			setup: func(cfg *config.Config, c *app.RequestContext) {
+				cfg.Shell.CompressIdentity = true
+				c.Request.Header.Set("Accept-Encoding", "gzip")
+			},
+			wantStatus: 200,
+			wantGzip:   true,
+			wantBody:   rewritten,
+		},
+		{
+			name: "compress identity without gzip client",
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Shell.CompressIdentity = true
+			},
+			wantStatus: 200,
+			wantBody:   rewritten,
+		},
+		{
+			name: "skip rewrite pattern",
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Shell.SkipRewritePatterns = []string{`^/vendor/`}
+			},
+			path:       "/vendor/install.sh",
+			wantStatus: 200,
+			wantBody:   script,
+		},
+		{
+			name:       "upstream error relayed by default",
+			path:       "/error.sh",
+			wantStatus: 403,
+			wantBody:   rewritten,
+		},
+		{
+			name: "upstream error replaced",
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Shell.ReplaceUpstreamErrors = true
+			},
+			path:             "/error.sh",
+			wantStatus:       403,
+			wantBodyContains: "Upstream returned 403",
+		},
+		{
+			name: "raw override passes through",
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Shell.AllowRawOverride = true
+			},
+			path:       "/install.sh?ghproxy_raw=1",
+			wantStatus: 200,
+			wantBody:   script,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.Editor = true
+			c := newTestRequestContext(http.MethodGet)
+			c.Request.SetHost("proxy.example")
+			if tt.setup != nil {
+				tt.setup(cfg, c)
+			}
+			// 与启动时一致, 编译跳过改写的路径正则
+			if err := compileSkipRewritePatterns(cfg); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { skipRewritePatterns = nil })
+			path := tt.path
+			if path == "" {
+				path = "/install.sh"
+			}
+
+			if status := doChunkedProxy(t, cfg, c, server.URL+path, MatcherRaw); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantUpstreamNoEncoding && upstreamAcceptEncoding != "" {
+				t.Errorf("upstream Accept-Encoding = %q, want empty", upstreamAcceptEncoding)
+			}
+			body := c.Response.Body()
+			gotGzip := string(c.Response.Header.Peek("Content-Encoding")) == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("Content-Encoding gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			if gotGzip {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				if body, err = io.ReadAll(gz); err != nil {
+					t.Fatalf("read gzip body: %v", err)
+				}
+			}
+			if tt.wantBodyContains != "" {
+				if !strings.Contains(string(body), tt.wantBodyContains) {
+					t.Errorf("body = %q, want containing %q", body, tt.wantBodyContains)
+				}
+				return
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// release资源的 Content-Disposition 原样转发, 开启 sanitizeDisposition 时去除路径部分
+func TestChunkedProxyContentDisposition(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../app.tar.gz"`)
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		sanitize bool
+		want     string
+	}{
+		{name: "forwarded", want: `attachment; filename="../app.tar.gz"`},
+		{name: "sanitized", sanitize: true, want: `attachment; filename=app.tar.gz`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.SanitizeDisposition = tt.sanitize
+			c := newTestRequestContext(http.MethodGet)
+			if status := doChunkedProxy(t, cfg, c, server.URL+"/user/repo/releases/download/v1.0/app.tar.gz", MatcherReleases); status != 200 {
+				t.Fatalf("status = %d, want 200", status)
+			}
+			if got := string(c.Response.Header.Peek("Content-Disposition")); got != tt.want {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 固定SHA的raw内容可长期缓存, 分支内容不缓存, 上游非200时不设置
+func TestChunkedProxyCacheControl(t *testing.T) {
+	const sha = "3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/gone.txt") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		enabled bool
+		ref     string
+		path    string
+		want    string
+	}{
+		{name: "disabled", ref: sha, path: "/a.txt"},
+		{name: "sha", enabled: true, ref: sha, path: "/a.txt", want: "public, max-age=31536000, immutable"},
+		{name: "branch", enabled: true, ref: "main", path: "/a.txt", want: "no-cache"},
+		{name: "upstream error", enabled: true, ref: sha, path: "/gone.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.CacheControl.Enabled = tt.enabled
+			cfg.Shell.CacheControl.ShaMaxAge = 31536000
+			c := newTestRequestContext(http.MethodGet)
+			c.Set("ref", tt.ref)
+			doChunkedProxy(t, cfg, c, server.URL+tt.path, MatcherRaw)
+			if got := string(c.Response.Header.Peek("Cache-Control")); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 条件请求头转发到上游, 304 原样返回且不进入改写流程
+func TestChunkedProxyNotModified(t *testing.T) {
+	const etag = `"abc123"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("curl -fsSL https://github.com/user/repo/raw/main/install.sh\n"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		customHeaders bool
+		ifNoneMatch   string
+		wantStatus    int
+	}{
+		{name: "no condition", wantStatus: 200},
+		{name: "matching etag", ifNoneMatch: etag, wantStatus: 304},
+		{name: "matching etag with custom raw headers", customHeaders: true, ifNoneMatch: etag, wantStatus: 304},
+		{name: "stale etag", ifNoneMatch: `"old"`, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.Editor = true
+			cfg.Httpc.UseCustomRawHeaders = tt.customHeaders
+			c := newTestRequestContext(http.MethodGet)
+			c.Request.SetHost("proxy.example")
+			if tt.ifNoneMatch != "" {
+				c.Request.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			if status := doChunkedProxy(t, cfg, c, server.URL+"/install.sh", MatcherRaw); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if got := string(c.Response.Header.Peek("ETag")); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if tt.wantStatus == 304 && len(c.Response.Body()) != 0 {
+				t.Errorf("304 body = %q, want empty", c.Response.Body())
+			}
+		})
+	}
+}
+
+// 改写会改变body长度, 不得沿用上游的 Content-Length; 透传时保留
+func TestChunkedProxyContentLength(t *testing.T) {
+	const script = "curl -fsSL https://github.com/user/repo/raw/main/install.sh\n"
+	const rewritten = "curl -fsSL https://proxy.example/https://github.com/user/repo/raw/main/install.sh\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/chunked") {
+			// 先刷新header, 以chunked传输且不带 Content-Length
+			w.(http.Flusher).Flush()
+			w.Write([]byte(script))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(script)))
+		w.Write([]byte(script))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name              string
+		setup             func(cfg *config.Config)
+		path              string
+		wantBody          string
+		wantContentLength int // -1 为chunked
+	}{
+		{name: "rewritten", path: "/install.sh", wantBody: rewritten, wantContentLength: -1},
+		{name: "passthrough", path: "/install.txt", wantBody: script, wantContentLength: len(script)},
+		{name: "chunked rewritten", path: "/chunked/install.sh", wantBody: rewritten, wantContentLength: -1},
+		{name: "chunked passthrough", path: "/chunked/install.txt", wantBody: script, wantContentLength: -1},
+		{name: "buffered below threshold", setup: func(cfg *config.Config) { cfg.Limits.BufferForLengthBytes = 1024 },
+			path: "/install.sh", wantBody: rewritten, wantContentLength: len(rewritten)},
+		{name: "streamed above threshold", setup: func(cfg *config.Config) { cfg.Limits.BufferForLengthBytes = 16 },
+			path: "/install.sh", wantBody: rewritten, wantContentLength: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.Editor = true
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			c := newTestRequestContext(http.MethodGet)
+			c.Request.SetHost("proxy.example")
+			if status := doChunkedProxy(t, cfg, c, server.URL+tt.path, MatcherRaw); status != 200 {
+				t.Fatalf("status = %d, want 200", status)
+			}
+			if got := c.Response.Header.ContentLength(); got != tt.wantContentLength {
+				t.Errorf("Content-Length = %d, want %d", got, tt.wantContentLength)
+			}
+			if string(c.Response.Body()) != tt.wantBody {
+				t.Errorf("body = %q, want %q", c.Response.Body(), tt.wantBody)
+			}
+		})
+	}
+}
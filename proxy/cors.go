@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// corsAllowOrigin 按 CORS 策略返回 Access-Control-Allow-Origin 的值, 不允许时返回空
+func corsAllowOrigin(origin string, cfg *config.Config) string {
+	for _, allowed := range cfg.Server.CORS.Origins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// setCORSHeaders 写入响应的CORS头
+// 未启用 CORS 策略时沿用 cors 配置项的行为
+func setCORSHeaders(c *app.RequestContext, cfg *config.Config) {
+	if !cfg.Server.CORS.Enabled {
+		switch cfg.Server.Cors {
+		case "*":
+			c.Header("Access-Control-Allow-Origin", "*")
+		case "":
+			c.Header("Access-Control-Allow-Origin", "*")
+		case "nil":
+			c.Header("Access-Control-Allow-Origin", "")
+		default:
+			c.Header("Access-Control-Allow-Origin", cfg.Server.Cors)
+		}
+		return
+	}
+
+	allowOrigin := corsAllowOrigin(string(c.GetHeader("Origin")), cfg)
+	if allowOrigin == "" {
+		return
+	}
+	c.Header("Access-Control-Allow-Origin", allowOrigin)
+	if allowOrigin != "*" {
+		// 按来源返回不同的值, 缓存需区分Origin
+		c.Response.Header.Add("Vary", "Origin")
+	}
+}
+
+// corsPreflight 处理 OPTIONS 预检请求, 已处理时返回true
+func corsPreflight(c *app.RequestContext, cfg *config.Config) bool {
+	if !cfg.Server.CORS.Enabled || string(c.Method()) != "OPTIONS" || len(c.GetHeader("Access-Control-Request-Method")) == 0 {
+		return false
+	}
+
+	setCORSHeaders(c, cfg)
+	if len(c.Response.Header.Peek("Access-Control-Allow-Origin")) > 0 {
+		methods := cfg.Server.CORS.Methods
+		if len(methods) == 0 {
+			methods = []string{"GET", "HEAD", "OPTIONS"}
+		}
+		c.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(cfg.Server.CORS.Headers) > 0 {
+			c.Header("Access-Control-Allow-Headers", strings.Join(cfg.Server.CORS.Headers, ", "))
+		} else if reqHeaders := c.GetHeader("Access-Control-Request-Headers"); len(reqHeaders) > 0 {
+			c.Header("Access-Control-Allow-Headers", string(reqHeaders))
+		}
+		if cfg.Server.CORS.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(cfg.Server.CORS.MaxAge))
+		}
+	}
+	c.Status(204)
+	logDebug("%s %s %s %s %s CORS preflight", c.ClientIP(), c.Method(), c.Path(), c.Request.Header.UserAgent(), c.Request.Header.GetProtocol())
+	return true
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"ghproxy/config"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(cfg *config.Config)
+		origin      string
+		reqHeaders  string
+		wantHandled bool
+		wantOrigin  string
+		wantMethods string
+		wantHeaders string
+		wantMaxAge  string
+		wantVary    bool
+	}{
+		{name: "disabled", origin: "https://app.example"},
+		{name: "wildcard", setup: func(cfg *config.Config) {
+			cfg.Server.CORS = config.CORSConfig{Enabled: true, Origins: []string{"*"}}
+		}, origin: "https://app.example", reqHeaders: "Range", wantHandled: true, wantOrigin: "*", wantMethods: "GET, HEAD, OPTIONS", wantHeaders: "Range"},
+		{name: "listed origin", setup: func(cfg *config.Config) {
+			cfg.Server.CORS = config.CORSConfig{Enabled: true, Origins: []string{"https://app.example"}, Methods: []string{"GET"}, Headers: []string{"Authorization"}, MaxAge: 600}
+		}, origin: "https://APP.example", reqHeaders: "Range", wantHandled: true, wantOrigin: "https://APP.example", wantMethods: "GET", wantHeaders: "Authorization", wantMaxAge: "600", wantVary: true},
+		{name: "unlisted origin", setup: func(cfg *config.Config) {
+			cfg.Server.CORS = config.CORSConfig{Enabled: true, Origins: []string{"https://app.example"}}
+		}, origin: "https://evil.example", wantHandled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			c := newTestRequestContext("OPTIONS")
+			c.Request.SetRequestURI("/https://github.com/user/repo/raw/main/a.sh")
+			c.Request.Header.Set("Origin", tt.origin)
+			c.Request.Header.Set("Access-Control-Request-Method", "GET")
+			if tt.reqHeaders != "" {
+				c.Request.Header.Set("Access-Control-Request-Headers", tt.reqHeaders)
+			}
+
+			if handled := corsPreflight(c, cfg); handled != tt.wantHandled {
+				t.Fatalf("corsPreflight = %v, want %v", handled, tt.wantHandled)
+			}
+			if !tt.wantHandled {
+				return
+			}
+			if status := c.Response.StatusCode(); status != 204 {
+				t.Errorf("status = %d, want 204", status)
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":  tt.wantOrigin,
+				"Access-Control-Allow-Methods": tt.wantMethods,
+				"Access-Control-Allow-Headers": tt.wantHeaders,
+				"Access-Control-Max-Age":       tt.wantMaxAge,
+			} {
+				if got := string(c.Response.Header.Peek(header)); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if gotVary := string(c.Response.Header.Peek("Vary")) == "Origin"; gotVary != tt.wantVary {
+				t.Errorf("Vary Origin = %v, want %v", gotVary, tt.wantVary)
+			}
+		})
+	}
+}
+
+// 预检请求在handler中先于匹配流程处理
+func TestNoRouteHandlerCORSPreflight(t *testing.T) {
+	captured := stopAtValidator(t)
+	cfg := proxyTestConfig()
+	cfg.Server.CORS = config.CORSConfig{Enabled: true, Origins: []string{"*"}}
+	c := newTestRequestContext("OPTIONS")
+	c.Request.SetRequestURI("/https://github.com/user/repo/raw/main/a.sh")
+	c.Request.Header.Set("Origin", "https://app.example")
+	c.Request.Header.Set("Access-Control-Request-Method", "GET")
+
+	NoRouteHandler(cfg, nil, nil)(context.Background(), c)
+
+	if status := c.Response.StatusCode(); status != 204 {
+		t.Fatalf("status = %d, want 204", status)
+	}
+	if *captured != nil {
+		t.Error("preflight request reached the matcher flow")
+	}
+}
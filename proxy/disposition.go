@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"mime"
+	"strings"
+)
+
+func stripControlChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sanitizeDispositionFilename 去除文件名中的路径部分与控制字符
+func sanitizeDispositionFilename(name string) string {
+	if i := strings.LastIndexAny(name, "/\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = stripControlChars(name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "download"
+	}
+	return name
+}
+
+// sanitizeDisposition 清理 Content-Disposition 中的 filename / filename* 参数
+// 无法解析时仅移除控制字符, 避免客户端错误保存文件
+func sanitizeDisposition(value string) string {
+	dispType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return stripControlChars(value)
+	}
+	// ParseMediaType 会将 filename* 解码后合并到 filename
+	if name, ok := params["filename"]; ok {
+		params["filename"] = sanitizeDispositionFilename(name)
+	}
+	formatted := mime.FormatMediaType(dispType, params)
+	if formatted == "" {
+		return dispType
+	}
+	return formatted
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import "testing"
+
+func TestSanitizeDisposition(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: `attachment; filename=app.tar.gz`, want: `attachment; filename=app.tar.gz`},
+		{in: `attachment; filename="../../etc/passwd"`, want: `attachment; filename=passwd`},
+		{in: `attachment; filename="C:\\Windows\\app.exe"`, want: `attachment; filename=app.exe`},
+		{in: `attachment; filename=".."`, want: `attachment; filename=download`},
+		{in: `attachment; filename*=UTF-8''%E4%B8%AD%E6%96%87.zip`, want: `attachment; filename*=utf-8''%E4%B8%AD%E6%96%87.zip`},
+		{in: `attachment; filename*=UTF-8''..%2F..%2Fevil.sh`, want: `attachment; filename=evil.sh`},
+		{in: `inline`, want: `inline`},
+		{in: "attachment; filename=\"a\x01b.zip", want: `attachment; filename="ab.zip`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := sanitizeDisposition(tt.in); got != tt.want {
+				t.Errorf("sanitizeDisposition(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
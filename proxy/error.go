@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"html/template"
 	"io/fs"
+	"net/http"
+	"strings"
 
 	"github.com/WJQSERVER-STUDIO/logger"
 	"github.com/cloudwego/hertz/pkg/app"
@@ -58,30 +60,58 @@ var (
 		StatusText: "页面未找到",
 		HelpInfo:   "抱歉，您访问的页面不存在。",
 	}
+	ErrRequestEntityTooLarge = &GHProxyErrors{
+		StatusCode: 413,
+		StatusDesc: "Request Entity Too Large",
+		StatusText: "请求体过大",
+		HelpInfo:   "请求体超过了服务器允许的大小。",
+	}
 	ErrTooManyRequests = &GHProxyErrors{
 		StatusCode: 429,
 		StatusDesc: "Too Many Requests",
 		StatusText: "请求过于频繁",
 		HelpInfo:   "您的请求过于频繁，请稍后再试。",
 	}
+	ErrRequestHeaderFieldsTooLarge = &GHProxyErrors{
+		StatusCode: 431,
+		StatusDesc: "Request Header Fields Too Large",
+		StatusText: "请求头过大",
+		HelpInfo:   "请求头超过了服务器允许的大小，请精简后重试。",
+	}
 	ErrInternalServerError = &GHProxyErrors{
 		StatusCode: 500,
 		StatusDesc: "Internal Server Error",
 		StatusText: "服务器内部错误",
 		HelpInfo:   "服务器处理您的请求时发生错误，请稍后重试或联系管理员。",
 	}
+	ErrNotImplemented = &GHProxyErrors{
+		StatusCode: 501,
+		StatusDesc: "Not Implemented",
+		StatusText: "不支持的请求",
+		HelpInfo:   "服务器不支持此类请求。",
+	}
+	ErrBadGateway = &GHProxyErrors{
+		StatusCode: 502,
+		StatusDesc: "Bad Gateway",
+		StatusText: "上游错误",
+		HelpInfo:   "上游服务器返回了无效的响应，请稍后重试。",
+	}
 )
 
 var statusErrorMap map[int]*GHProxyErrors
 
 func init() {
 	statusErrorMap = map[int]*GHProxyErrors{
-		ErrInvalidURL.StatusCode:            ErrInvalidURL,
-		ErrAuthHeaderUnavailable.StatusCode: ErrAuthHeaderUnavailable,
-		ErrForbidden.StatusCode:             ErrForbidden,
-		ErrNotFound.StatusCode:              ErrNotFound,
-		ErrTooManyRequests.StatusCode:       ErrTooManyRequests,
-		ErrInternalServerError.StatusCode:   ErrInternalServerError,
+		ErrInvalidURL.StatusCode:                  ErrInvalidURL,
+		ErrAuthHeaderUnavailable.StatusCode:       ErrAuthHeaderUnavailable,
+		ErrForbidden.StatusCode:                   ErrForbidden,
+		ErrNotFound.StatusCode:                    ErrNotFound,
+		ErrRequestEntityTooLarge.StatusCode:       ErrRequestEntityTooLarge,
+		ErrTooManyRequests.StatusCode:             ErrTooManyRequests,
+		ErrRequestHeaderFieldsTooLarge.StatusCode: ErrRequestHeaderFieldsTooLarge,
+		ErrInternalServerError.StatusCode:         ErrInternalServerError,
+		ErrNotImplemented.StatusCode:              ErrNotImplemented,
+		ErrBadGateway.StatusCode:                  ErrBadGateway,
 	}
 }
 
@@ -133,7 +163,50 @@ func ErrPageUnwarper(errInfo *GHProxyErrors) ErrorPageData {
 	}
 }
 
+// 错误响应格式, 见 config.Server.ErrorFormat
+var errorFormat = "html"
+
+func SetErrorFormat(format string) {
+	switch format {
+	case "text", "json", "auto":
+		errorFormat = format
+	default:
+		errorFormat = "html"
+	}
+}
+
+// wantJSONError 判断 auto 模式下是否返回json错误
+func wantJSONError(c *app.RequestContext) bool {
+	if c.GetString("matcher") == string(MatcherAPI) {
+		return true
+	}
+	return strings.Contains(string(c.GetHeader("Accept")), "application/json")
+}
+
 func ErrorPage(c *app.RequestContext, errInfo *GHProxyErrors) {
+	format := errorFormat
+	if format == "auto" {
+		if wantJSONError(c) {
+			format = "json"
+		} else {
+			format = "html"
+		}
+	}
+	switch format {
+	case "json":
+		c.JSON(errInfo.StatusCode, map[string]interface{}{
+			"status":  errInfo.StatusCode,
+			"message": errInfo.ErrorMessage,
+		})
+		return
+	case "text":
+		desc := errInfo.StatusDesc
+		if desc == "" {
+			desc = http.StatusText(errInfo.StatusCode)
+		}
+		c.Data(errInfo.StatusCode, "text/plain; charset=utf-8", []byte(fmt.Sprintf("%d %s: %s\n", errInfo.StatusCode, desc, errInfo.ErrorMessage)))
+		return
+	}
 	pageData, err := htmlTemplateRender(errPagesFs, ErrPageUnwarper(errInfo))
 	if err != nil {
 		c.JSON(errInfo.StatusCode, map[string]string{"error": errInfo.ErrorMessage})
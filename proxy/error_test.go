@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestErrorPageFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		matcher   MatcherType
+		accept    string
+		wantJSON  bool
+		wantText  string // 非json时期望的body
+		wantCtype string
+	}{
+		{name: "text", format: "text", wantText: "403 Forbidden: blocked\n", wantCtype: "text/plain"},
+		{name: "json", format: "json", wantJSON: true, wantCtype: "application/json"},
+		{name: "auto api matcher", format: "auto", matcher: MatcherAPI, wantJSON: true, wantCtype: "application/json"},
+		{name: "auto json accept", format: "auto", accept: "application/vnd.github+json, application/json", wantJSON: true, wantCtype: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := errorFormat
+			SetErrorFormat(tt.format)
+			t.Cleanup(func() { SetErrorFormat(prev) })
+			c := newTestRequestContext("GET")
+			if tt.matcher != "" {
+				c.Set("matcher", string(tt.matcher))
+			}
+			if tt.accept != "" {
+				c.Request.Header.Set("Accept", tt.accept)
+			}
+			errInfo := NewErrorWithStatusLookup(403, "blocked")
+
+			ErrorPage(c, errInfo)
+
+			if status := c.Response.StatusCode(); status != 403 {
+				t.Fatalf("status = %d, want 403", status)
+			}
+			if ctype := string(c.Response.Header.ContentType()); !strings.HasPrefix(ctype, tt.wantCtype) {
+				t.Errorf("Content-Type = %q, want %q", ctype, tt.wantCtype)
+			}
+			if !tt.wantJSON {
+				if string(c.Response.Body()) != tt.wantText {
+					t.Errorf("body = %q, want %q", c.Response.Body(), tt.wantText)
+				}
+				return
+			}
+			var body struct {
+				Status  int    `json:"status"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(c.Response.Body(), &body); err != nil {
+				t.Fatalf("invalid json body %q: %v", c.Response.Body(), err)
+			}
+			if body.Status != 403 || body.Message != "blocked" {
+				t.Errorf("body = %+v, want status 403 and message blocked", body)
+			}
+		})
+	}
+}
+
+func TestSetErrorFormatUnknown(t *testing.T) {
+	prev := errorFormat
+	SetErrorFormat("yaml")
+	t.Cleanup(func() { SetErrorFormat(prev) })
+	if errorFormat != "html" {
+		t.Errorf("errorFormat = %q, want html", errorFormat)
+	}
+}
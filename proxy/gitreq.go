@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"ghproxy/config"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -31,9 +32,12 @@ func GitReq(ctx context.Context, c *app.RequestContext, u string, cfg *config.Co
 
 	method := string(c.Request.Method())
 
-	reqBodyReader := bytes.NewBuffer(c.Request.Body())
-
-	//bodyReader := c.Request.BodyStream() // 不可替换为此实现
+	// 请求体流式转发, 不完全读入内存
+	reqBodyReader, reqBodyLength, err := gitRequestBody(c)
+	if err != nil {
+		HandleError(c, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
 
 	if cfg.GitClone.Mode == "cache" {
 		userPath, repoPath, remainingPath, queryParams, err := extractParts(u)
@@ -49,19 +53,26 @@ func GitReq(ctx context.Context, c *app.RequestContext, u string, cfg *config.Co
 		rb := gitclient.NewRequestBuilder(method, u)
 		rb.NoDefaultHeaders()
 		rb.SetBody(reqBodyReader)
-		rb.WithContext(ctx)
+		upstreamCtx, upstreamErr := withUpstreamErrHolder(withRedirectLimit(ctx, redirectLimitFor(MatcherClone, cfg)), c)
+		rb.WithContext(upstreamCtx)
 
 		req, err := rb.Build()
 		if err != nil {
 			HandleError(c, fmt.Sprintf("Failed to create request: %v", err))
 			return
 		}
+		setRequestBodyLength(req, reqBodyLength)
 
-		setRequestHeaders(c, req, cfg, "clone")
+		setRequestHeaders(c, req, cfg, MatcherClone)
 		AuthPassThrough(c, cfg, req)
 
 		resp, err = gitclient.Do(req)
 		if err != nil {
+			err = upstreamErr.resolve(err)
+			if status, ok := upstreamErrorStatus(err); ok {
+				ErrorPage(c, NewErrorWithStatusLookup(status, err.Error()))
+				return
+			}
 			HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
 			return
 		}
@@ -69,19 +80,26 @@ func GitReq(ctx context.Context, c *app.RequestContext, u string, cfg *config.Co
 		rb := client.NewRequestBuilder(string(c.Request.Method()), u)
 		rb.NoDefaultHeaders()
 		rb.SetBody(reqBodyReader)
-		rb.WithContext(ctx)
+		upstreamCtx, upstreamErr := withUpstreamErrHolder(withRedirectLimit(ctx, redirectLimitFor(MatcherClone, cfg)), c)
+		rb.WithContext(upstreamCtx)
 
 		req, err := rb.Build()
 		if err != nil {
 			HandleError(c, fmt.Sprintf("Failed to create request: %v", err))
 			return
 		}
+		setRequestBodyLength(req, reqBodyLength)
 
-		setRequestHeaders(c, req, cfg, "clone")
+		setRequestHeaders(c, req, cfg, MatcherClone)
 		AuthPassThrough(c, cfg, req)
 
 		resp, err = client.Do(req)
 		if err != nil {
+			err = upstreamErr.resolve(err)
+			if status, ok := upstreamErrorStatus(err); ok {
+				ErrorPage(c, NewErrorWithStatusLookup(status, err.Error()))
+				return
+			}
 			HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
 			return
 		}
@@ -103,6 +121,9 @@ func GitReq(ctx context.Context, c *app.RequestContext, u string, cfg *config.Co
 	}
 
 	for key, values := range resp.Header {
+		if !responseHeaderAllowed(cfg, MatcherClone, key) {
+			continue
+		}
 		for _, value := range values {
 			c.Response.Header.Add(key, value)
 		}
@@ -118,16 +139,7 @@ func GitReq(ctx context.Context, c *app.RequestContext, u string, cfg *config.Co
 		resp.Header.Del(header)
 	}
 
-	switch cfg.Server.Cors {
-	case "*":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "nil":
-		c.Header("Access-Control-Allow-Origin", "")
-	default:
-		c.Header("Access-Control-Allow-Origin", cfg.Server.Cors)
-	}
+	setCORSHeaders(c, cfg)
 
 	c.Status(resp.StatusCode)
 	if cfg.GitClone.Mode == "cache" {
@@ -142,5 +154,30 @@ func GitReq(ctx context.Context, c *app.RequestContext, u string, cfg *config.Co
 		bodyReader = limitreader.NewRateLimitedReader(bodyReader, bandwidthLimit, int(bandwidthBurst), ctx)
 	}
 
-	c.SetBodyStream(bodyReader, -1)
+	c.SetBodyStream(newStreamGauge(newStatsReader(bodyReader, GlobalStats), GlobalStats, string(MatcherClone)), -1)
+}
+
+// gitRequestBody 返回转发给上游的请求体及其长度, 长度未知时为-1
+// 启用StreamBody时请求体为流, 直接转发给上游连接;
+// 请求体已被完整读取时 BodyStream 为 NoBody, 不能直接使用, 需使用已读取的body
+func gitRequestBody(c *app.RequestContext) (io.Reader, int64, error) {
+	if c.Request.IsBodyStream() {
+		return c.Request.BodyStream(), int64(c.Request.Header.ContentLength()), nil
+	}
+	body, err := c.Request.BodyE()
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(body), int64(len(body)), nil
+}
+
+// setRequestBodyLength 设置上游请求的长度, 长度未知时使用chunked传输
+func setRequestBodyLength(req *http.Request, length int64) {
+	switch {
+	case length == 0:
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	case length > 0:
+		req.ContentLength = length
+	}
 }
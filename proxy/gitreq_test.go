@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// 多轮 protocol v2 协商的请求体应完整转发, 不受截断
+func TestGitReqForwardsNegotiationBody(t *testing.T) {
+	var body bytes.Buffer
+	body.WriteString("0014command=fetch\n0001")
+	for i := 0; i < 40000; i++ {
+		line := fmt.Sprintf("want %040x\n", i)
+		fmt.Fprintf(&body, "%04x%s", len(line)+4, line)
+	}
+	body.WriteString("0009done\n0000")
+	want := sha256.Sum256(body.Bytes())
+
+	var gotLen int
+	var gotSum [32]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotLen, gotSum = len(data), sha256.Sum256(data)
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		w.Write([]byte("0008NAK\n"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		streamed bool // 以未知长度的body stream发送
+		setup    func(cfg *config.Config)
+	}{
+		{name: "fixed length"},
+		{name: "streamed", streamed: true},
+		{name: "global limit with clone override", streamed: true, setup: func(cfg *config.Config) {
+			cfg.Limits.MaxRequestBodyBytes = 1024
+			cfg.Limits.MatcherMaxRequestBodyBytes = map[string]int64{"clone": 0}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLen, gotSum = 0, [32]byte{}
+			cfg := proxyTestConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			initHTTPClient(cfg)
+			t.Cleanup(func() { initHTTPClient(config.DefaultConfig()) })
+
+			c := newTestRequestContext(http.MethodPost)
+			c.Request.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+			c.Request.Header.Set("Git-Protocol", "version=2")
+			if tt.streamed {
+				c.Request.SetBodyStream(bytes.NewReader(body.Bytes()), -1)
+			} else {
+				c.Request.SetBody(body.Bytes())
+			}
+			if bodySizeCheck(cfg, c, MatcherClone, "") {
+				t.Fatalf("bodySizeCheck rejected the request: %d", c.Response.StatusCode())
+			}
+
+			GitReq(context.Background(), c, server.URL+"/user/repo.git/git-upload-pack", cfg, "git")
+
+			if status := c.Response.StatusCode(); status != 200 {
+				t.Fatalf("status = %d, want 200: %s", status, c.Response.Body())
+			}
+			if gotLen != body.Len() || gotSum != want {
+				t.Fatalf("upstream received %d bytes (sha match %v), want %d", gotLen, gotSum == want, body.Len())
+			}
+			if !strings.Contains(string(c.Response.Body()), "NAK") {
+				t.Errorf("body = %q, want upstream response", c.Response.Body())
+			}
+		})
+	}
+}
+
+func TestGitRequestBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(c *app.RequestContext)
+		wantBody   string
+		wantLength int64
+	}{
+		{name: "buffered", setup: func(c *app.RequestContext) { c.Request.SetBody([]byte("0009done\n")) },
+			wantBody: "0009done\n", wantLength: 9},
+		{name: "empty", setup: func(c *app.RequestContext) {}, wantLength: 0},
+		{name: "stream with length", setup: func(c *app.RequestContext) {
+			c.Request.SetBodyStream(strings.NewReader("0009done\n"), 9)
+		}, wantBody: "0009done\n", wantLength: 9},
+		{name: "stream without length", setup: func(c *app.RequestContext) {
+			c.Request.SetBodyStream(strings.NewReader("0009done\n"), -1)
+		}, wantBody: "0009done\n", wantLength: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestRequestContext(http.MethodPost)
+			tt.setup(c)
+
+			reader, length, err := gitRequestBody(c)
+			if err != nil {
+				t.Fatalf("gitRequestBody: %v", err)
+			}
+			if length != tt.wantLength {
+				t.Errorf("length = %d, want %d", length, tt.wantLength)
+			}
+			data, _ := io.ReadAll(reader)
+			if string(data) != tt.wantBody {
+				t.Errorf("body = %q, want %q", data, tt.wantBody)
+			}
+
+			// 上游请求: 长度已知时设置 Content-Length, 未知时使用chunked, 为0时不带body
+			req, _ := http.NewRequest(http.MethodPost, "http://upstream.example/", io.NopCloser(strings.NewReader(tt.wantBody)))
+			setRequestBodyLength(req, length)
+			switch {
+			case length == 0:
+				if req.Body != http.NoBody || req.ContentLength != 0 {
+					t.Errorf("empty body = %v/%d, want NoBody/0", req.Body, req.ContentLength)
+				}
+			case length > 0:
+				if req.ContentLength != length {
+					t.Errorf("ContentLength = %d, want %d", req.ContentLength, length)
+				}
+			default:
+				if req.ContentLength != 0 {
+					t.Errorf("ContentLength = %d, want 0 (chunked)", req.ContentLength)
+				}
+			}
+		})
+	}
+}
@@ -16,18 +16,35 @@ var re = regexp.MustCompile(`^(http:|https:)?/?/?(.*)`) // 匹配http://或https
 func NoRouteHandler(cfg *config.Config, limiter *rate.RateLimiter, iplimiter *rate.IPRateLimiter) app.HandlerFunc {
 	return func(ctx context.Context, c *app.RequestContext) {
 
+		// 保留的健康检查路径, 不进入常规matcher流程
+		if isHealthPath(string(c.Request.URI().Path()), cfg) {
+			HealthHandler(c, cfg)
+			return
+		}
+
+		// CORS 预检请求, 不进入matcher流程
+		if corsPreflight(c, cfg) {
+			return
+		}
+
 		var shoudBreak bool
 		shoudBreak = rateCheck(cfg, c, limiter, iplimiter)
 		if shoudBreak {
 			return
 		}
 
+		shoudBreak = upgradeCheck(c)
+		if shoudBreak {
+			return
+		}
+
 		var (
 			rawPath string
 			matches []string
 		)
 
 		rawPath = strings.TrimPrefix(string(c.Request.RequestURI()), "/") // 去掉前缀/
+		rawPath = stripPathPrefix(rawPath, cfg)                           // 去掉子路径部署前缀
 		matches = re.FindStringSubmatch(rawPath)                          // 匹配路径
 
 		// 匹配路径错误处理
@@ -37,46 +54,97 @@ func NoRouteHandler(cfg *config.Config, limiter *rate.RateLimiter, iplimiter *ra
 			return
 		}
 
+		requestedPath := rawPath
+
 		// 制作url
 		rawPath = "https://" + matches[2]
 
+		// 解开嵌套的代理前缀, 如 https://host/https://github.com/...
+		if cfg.Shell.PreventDoubleProxy {
+			rawPath, _ = unwrapProxyURL(rawPath, string(c.Request.Host()), cfg)
+		}
+
 		var (
 			user    string
 			repo    string
-			matcher string
+			matcher MatcherType
 		)
 
-		var matcherErr *GHProxyErrors
-		user, repo, matcher, matcherErr = Matcher(rawPath, cfg)
+		result, matcherErr := Matcher(rawPath, cfg)
 		if matcherErr != nil {
+			if result != nil {
+				logDebug("%s %s %s %s %s Matcher-Error: %s, Matched-Username: %s, Matched-Repo: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), matcherErr.ErrorMessage, result.User, result.Repo)
+			}
+			auditReject(cfg, c, rawPath, result, matcherErr)
 			ErrorPage(c, matcherErr)
 			return
 		}
+		user = result.User
+		repo = result.Repo
+		matcher = result.Matcher
+		rawPath = result.URL
+		c.Set("matcher", string(matcher))
+		c.Set("ref", result.Ref)
+
+		shoudBreak = canonicalCheck(cfg, c, requestedPath, result)
+		if shoudBreak {
+			return
+		}
 
 		logDump("%s %s %s %s %s Matched-Username: %s, Matched-Repo: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), user, repo)
-		logDump("%s", c.Request.Header.Header())
+		logDump("%s", redactedHeaders(c))
+
+		shoudBreak = disabledCheck(cfg, c, matcher, user, repo, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = headerSizeCheck(cfg, c, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = bodySizeCheck(cfg, c, matcher, rawPath)
+		if shoudBreak {
+			return
+		}
 
 		shoudBreak = listCheck(cfg, c, user, repo, rawPath)
 		if shoudBreak {
 			return
 		}
 
-		shoudBreak = authCheck(c, cfg, matcher, rawPath)
+		shoudBreak = refCheck(cfg, c, result, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = authCheck(c, cfg, result, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = validateCheck(c, result, rawPath)
 		if shoudBreak {
 			return
 		}
 
 		// 处理blob/raw路径
-		if matcher == "blob" {
+		if matcher == MatcherBlob {
 			rawPath = strings.Replace(rawPath, "/blob/", "/raw/", 1)
 		}
 
 		logDebug("Matched: %v", matcher)
 
+		shoudBreak = redirectCheck(cfg, c, matcher, rawPath)
+		if shoudBreak {
+			return
+		}
+
 		switch matcher {
-		case "releases", "blob", "raw", "gist", "api":
+		case MatcherReleases, MatcherBlob, MatcherRaw, MatcherTree, MatcherGist, MatcherAPI, MatcherLFS, MatcherPackages, MatcherObject, MatcherFind, MatcherBlame:
 			ChunkedProxyRequest(ctx, c, rawPath, cfg, matcher)
-		case "clone":
+		case MatcherClone:
 			GitReq(ctx, c, rawPath, cfg, "git")
 		default:
 			ErrorPage(c, NewErrorWithStatusLookup(500, "Matched But Not Matched"))
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"ghproxy/config"
+)
+
+func TestNoRouteHandlerPathPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		path       string
+		wantStatus int
+		wantURL    string
+	}{
+		{name: "no prefix", path: "/https://github.com/user/repo/raw/main/a.sh", wantStatus: 403, wantURL: "https://github.com/user/repo/raw/main/a.sh"},
+		{name: "prefix", prefix: "/ghproxy", path: "/ghproxy/https://github.com/user/repo/raw/main/a.sh", wantStatus: 403, wantURL: "https://github.com/user/repo/raw/main/a.sh"},
+		{name: "prefix with slashes", prefix: "/ghproxy/", path: "/ghproxy/github.com/user/repo/raw/main/a.sh", wantStatus: 403, wantURL: "https://github.com/user/repo/raw/main/a.sh"},
+		{name: "nested prefix", prefix: "/tools/ghproxy", path: "/tools/ghproxy/https://github.com/user/repo/raw/main/a.sh", wantStatus: 403, wantURL: "https://github.com/user/repo/raw/main/a.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured := stopAtValidator(t)
+			cfg := proxyTestConfig()
+			cfg.Server.PathPrefix = tt.prefix
+			c := newTestRequestContext("GET")
+			c.Request.SetRequestURI(tt.path)
+
+			NoRouteHandler(cfg, nil, nil)(context.Background(), c)
+
+			if status := c.Response.StatusCode(); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+			if *captured == nil || (*captured).URL != tt.wantURL {
+				t.Errorf("matched = %+v, want URL %q", *captured, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestNoRouteHandlerNestedProxyURL(t *testing.T) {
+	captured := stopAtValidator(t)
+	cfg := proxyTestConfig()
+	cfg.Shell.PreventDoubleProxy = true
+	c := newTestRequestContext("GET")
+	c.Request.SetRequestURI("/https://proxy.example/https://github.com/user/repo/raw/main/a.sh")
+	c.Request.SetHost("proxy.example")
+
+	NoRouteHandler(cfg, nil, nil)(context.Background(), c)
+
+	if status := c.Response.StatusCode(); status != 403 {
+		t.Fatalf("status = %d, want 403 (body %q)", status, c.Response.Body())
+	}
+	if want := "https://github.com/user/repo/raw/main/a.sh"; *captured == nil || (*captured).URL != want {
+		t.Errorf("matched = %+v, want URL %q", *captured, want)
+	}
+}
+
+// 以下用例均在访问上游前结束: 被禁用(403)或重定向(302)
+func TestNoRouteHandlerMatcherPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(cfg *config.Config)
+		path         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "redirect release asset", setup: func(cfg *config.Config) { cfg.Shell.RedirectMatchers = []string{"releases"} },
+			path: "/https://github.com/user/repo/releases/download/v1.0/app.zip", wantStatus: 302,
+			wantLocation: "https://github.com/user/repo/releases/download/v1.0/app.zip"},
+		{name: "redirect blob as raw", setup: func(cfg *config.Config) { cfg.Shell.RedirectMatchers = []string{"blob"} },
+			path: "/https://github.com/user/repo/blob/main/a.sh", wantStatus: 302,
+			wantLocation: "https://github.com/user/repo/raw/main/a.sh"},
+		{name: "disabled matcher", setup: func(cfg *config.Config) { cfg.Disabled = []string{"gist"} },
+			path: "/https://gist.githubusercontent.com/user/abc123/raw/a.sh", wantStatus: 403},
+		{name: "other matcher not disabled", setup: func(cfg *config.Config) {
+			cfg.Disabled = []string{"gist"}
+			cfg.Shell.RedirectMatchers = []string{"raw"}
+		}, path: "/https://raw.githubusercontent.com/user/repo/main/a.sh", wantStatus: 302,
+			wantLocation: "https://raw.githubusercontent.com/user/repo/main/a.sh"},
+		{name: "disabled wins over redirect", setup: func(cfg *config.Config) {
+			cfg.Disabled = []string{"releases"}
+			cfg.Shell.RedirectMatchers = []string{"releases"}
+		}, path: "/https://github.com/user/repo/releases/download/v1.0/app.zip", wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			tt.setup(cfg)
+			c := newTestRequestContext("GET")
+			c.Request.SetRequestURI(tt.path)
+
+			NoRouteHandler(cfg, nil, nil)(context.Background(), c)
+
+			if status := c.Response.StatusCode(); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+			if got := string(c.Response.Header.Peek("Location")); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+// RoutingHandler 的路径不含scheme, 重定向时同样需返回绝对url
+func TestRoutingHandlerRedirectMatchers(t *testing.T) {
+	tests := []struct {
+		name         string
+		kind         string
+		matcher      MatcherType
+		filepath     string
+		wantLocation string
+	}{
+		{name: "release asset", kind: "releases", matcher: MatcherReleases, filepath: "/download/v1.0/app.zip",
+			wantLocation: "https://github.com/user/repo/releases/download/v1.0/app.zip"},
+		{name: "blob as raw", kind: "blob", matcher: MatcherBlob, filepath: "/main/a.sh",
+			wantLocation: "https://github.com/user/repo/raw/main/a.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.RedirectMatchers = []string{string(tt.matcher)}
+			c := newRouteContext(tt.kind, "user", "repo", tt.filepath)
+			c.Set("matcher", string(tt.matcher))
+
+			RoutingHandler(cfg, nil, nil)(context.Background(), c)
+
+			if status := c.Response.StatusCode(); status != 302 {
+				t.Fatalf("status = %d, want 302 (body %q)", status, c.Response.Body())
+			}
+			if got := string(c.Response.Header.Peek("Location")); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
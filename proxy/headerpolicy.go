@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"strings"
+)
+
+// headerPatternMatch 判断header名是否匹配规则, 不区分大小写, 支持结尾的 * 通配
+func headerPatternMatch(pattern string, key string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		prefix := strings.TrimSuffix(pattern, "*")
+		return len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix)
+	}
+	return strings.EqualFold(pattern, key)
+}
+
+func headerPatternsMatch(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if headerPatternMatch(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseHeaderAllowed 按照 ResponseHeaderPolicy 判断上游响应头是否可以转发
+// 若存在对应matcher的策略则优先使用, 否则使用全局策略
+func responseHeaderAllowed(cfg *config.Config, matcher MatcherType, key string) bool {
+	policy := cfg.Server.ResponseHeaderPolicy
+	allow := policy.Allow
+	deny := policy.Deny
+	if matcherPolicy, ok := policy.Matchers[string(matcher)]; ok {
+		allow = matcherPolicy.Allow
+		deny = matcherPolicy.Deny
+	}
+
+	if len(allow) > 0 && !headerPatternsMatch(allow, key) {
+		return false
+	}
+	return !headerPatternsMatch(deny, key)
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"testing"
+
+	"ghproxy/config"
+)
+
+func TestResponseHeaderAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   config.ResponseHeaderPolicyConfig
+		matcher  MatcherType
+		header   string
+		wantPass bool
+	}{
+		{name: "default forwards all", matcher: MatcherRaw, header: "X-GitHub-Request-Id", wantPass: true},
+		{name: "deny wildcard", policy: config.ResponseHeaderPolicyConfig{Deny: []string{"X-GitHub-*"}}, matcher: MatcherRaw, header: "X-GitHub-Request-Id"},
+		{name: "deny wildcard case insensitive", policy: config.ResponseHeaderPolicyConfig{Deny: []string{"x-github-*"}}, matcher: MatcherRaw, header: "X-Github-Request-Id"},
+		{name: "deny keeps content type", policy: config.ResponseHeaderPolicyConfig{Deny: []string{"X-GitHub-*"}}, matcher: MatcherRaw, header: "Content-Type", wantPass: true},
+		{name: "deny exact", policy: config.ResponseHeaderPolicyConfig{Deny: []string{"X-RateLimit-Remaining"}}, matcher: MatcherAPI, header: "X-RateLimit-Limit", wantPass: true},
+		{name: "allow list", policy: config.ResponseHeaderPolicyConfig{Allow: []string{"Content-*", "ETag"}}, matcher: MatcherRaw, header: "ETag", wantPass: true},
+		{name: "allow list rejects others", policy: config.ResponseHeaderPolicyConfig{Allow: []string{"Content-*", "ETag"}}, matcher: MatcherRaw, header: "X-Served-By"},
+		{name: "deny wins over allow", policy: config.ResponseHeaderPolicyConfig{Allow: []string{"X-*"}, Deny: []string{"X-GitHub-*"}}, matcher: MatcherRaw, header: "X-GitHub-Media-Type"},
+		{name: "matcher overrides global", policy: config.ResponseHeaderPolicyConfig{
+			Deny:     []string{"X-RateLimit-*"},
+			Matchers: map[string]config.HeaderPolicyConfig{"api": {}},
+		}, matcher: MatcherAPI, header: "X-RateLimit-Remaining", wantPass: true},
+		{name: "other matcher uses global", policy: config.ResponseHeaderPolicyConfig{
+			Deny:     []string{"X-RateLimit-*"},
+			Matchers: map[string]config.HeaderPolicyConfig{"api": {}},
+		}, matcher: MatcherRaw, header: "X-RateLimit-Remaining"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.ResponseHeaderPolicy = tt.policy
+			if got := responseHeaderAllowed(cfg, tt.matcher, tt.header); got != tt.wantPass {
+				t.Errorf("responseHeaderAllowed(%s, %q) = %v, want %v", tt.matcher, tt.header, got, tt.wantPass)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// 保留的健康检查路径
+const healthPath = "__ghproxy_health"
+
+// 健康检查时用于验证各matcher的样例url, 仅在本地匹配, 不会请求上游
+var healthProbes = []struct {
+	matcher MatcherType
+	url     string
+}{
+	{MatcherReleases, "https://github.com/WJQSERVER-STUDIO/ghproxy/releases/download/v1.0.0/ghproxy.tar.gz"},
+	{MatcherBlob, "https://github.com/WJQSERVER-STUDIO/ghproxy/blob/main/README.md"},
+	{MatcherRaw, "https://raw.githubusercontent.com/WJQSERVER-STUDIO/ghproxy/main/README.md"},
+	{MatcherGist, "https://gist.githubusercontent.com/user/abc123/raw/install.sh"},
+	{MatcherAPI, "https://api.github.com/repos/WJQSERVER-STUDIO/ghproxy"},
+	{MatcherClone, "https://github.com/WJQSERVER-STUDIO/ghproxy.git/info/refs"},
+}
+
+func isHealthPath(path string, cfg *config.Config) bool {
+	path = stripPathPrefix(strings.TrimPrefix(path, "/"), cfg)
+	return strings.TrimSuffix(path, "/") == healthPath
+}
+
+// HealthHandler 返回matcher子系统的状态, matchers 为各样例url的匹配结果
+func HealthHandler(c *app.RequestContext, cfg *config.Config) {
+	code, status := 200, "ok"
+	matchers := make(map[string]string, len(healthProbes))
+	for _, probe := range healthProbes {
+		result, err := matchRawPath(probe.url, cfg)
+		if err != nil || result.Matcher != probe.matcher {
+			code, status = 503, "error"
+			matchers[string(probe.matcher)] = "error"
+			continue
+		}
+		matchers[string(probe.matcher)] = "ok"
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, map[string]interface{}{
+		"status":   status,
+		"matchers": matchers,
+	})
+}
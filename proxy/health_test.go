@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		pathPrefix string
+		breakProbe bool // 将首个样例的期望matcher改为错误值
+		wantStatus int
+		wantState  string
+	}{
+		{name: "health", path: "/__ghproxy_health", wantStatus: 200, wantState: "ok"},
+		{name: "trailing slash", path: "/__ghproxy_health/", wantStatus: 200, wantState: "ok"},
+		{name: "path prefix", path: "/gh/__ghproxy_health", pathPrefix: "/gh", wantStatus: 200, wantState: "ok"},
+		{name: "probe mismatch", path: "/__ghproxy_health", breakProbe: true, wantStatus: 503, wantState: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured := stopAtValidator(t)
+			if tt.breakProbe {
+				saved := healthProbes[0].matcher
+				healthProbes[0].matcher = MatcherBlame
+				t.Cleanup(func() { healthProbes[0].matcher = saved })
+			}
+			cfg := proxyTestConfig()
+			cfg.Server.PathPrefix = tt.pathPrefix
+			c := newTestRequestContext("GET")
+			c.Request.SetRequestURI(tt.path)
+
+			NoRouteHandler(cfg, nil, nil)(context.Background(), c)
+
+			if got := c.Response.StatusCode(); got != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", got, tt.wantStatus, c.Response.Body())
+			}
+			if *captured != nil {
+				t.Fatalf("health request reached the matcher flow: %+v", *captured)
+			}
+			var body struct {
+				Status   string            `json:"status"`
+				Matchers map[string]string `json:"matchers"`
+			}
+			if err := json.Unmarshal(c.Response.Body(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != tt.wantState {
+				t.Errorf("status field = %q, want %q", body.Status, tt.wantState)
+			}
+			if len(body.Matchers) != len(healthProbes) {
+				t.Errorf("matchers = %v, want %d entries", body.Matchers, len(healthProbes))
+			}
+			if tt.breakProbe && body.Matchers[string(healthProbes[0].matcher)] != "error" {
+				t.Errorf("matchers = %v, want %s error", body.Matchers, healthProbes[0].matcher)
+			}
+		})
+	}
+}
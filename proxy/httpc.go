@@ -19,11 +19,23 @@ var (
 )
 
 func InitReq(cfg *config.Config) error {
+	if err := validateMatcherNames(cfg); err != nil {
+		return err
+	}
+	tlsConfig, err := loadUpstreamTLS(cfg)
+	if err != nil {
+		return err
+	}
+	upstreamTLS = tlsConfig
 	initHTTPClient(cfg)
 	if cfg.GitClone.Mode == "cache" {
 		initGitHTTPClient(cfg)
 	}
-	err := SetGlobalRateLimit(cfg)
+	if err := compileSkipRewritePatterns(cfg); err != nil {
+		return err
+	}
+	setRedirectAllowedHosts(cfg)
+	err = SetGlobalRateLimit(cfg)
 	if err != nil {
 		return err
 	}
@@ -67,6 +79,9 @@ func initHTTPClient(cfg *config.Config) {
 	if cfg.Outbound.Enabled {
 		initTransport(cfg, tr)
 	}
+	applyUpstreamTLS(tr)
+	applyPrivateIPBlock(cfg, tr)
+	tr.Proxy = redirectLimitProxy(tr.Proxy) // 限制上游重定向次数
 	if cfg.Server.Debug {
 		client = httpc.New(
 			httpc.WithTransport(tr),
@@ -111,6 +126,9 @@ func initGitHTTPClient(cfg *config.Config) {
 	if cfg.Outbound.Enabled {
 		initTransport(cfg, gittr)
 	}
+	applyUpstreamTLS(gittr)
+	applyPrivateIPBlock(cfg, gittr)
+	gittr.Proxy = redirectLimitProxy(gittr.Proxy) // 限制上游重定向次数
 	if cfg.Server.Debug && cfg.GitClone.ForceH2C {
 		gitclient = httpc.New(
 			httpc.WithTransport(gittr),
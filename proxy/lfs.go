@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"strings"
+)
+
+// LFS 对象存储使用的域名
+var lfsObjectHosts = []string{
+	"github-cloud.githubusercontent.com",
+	"github-cloud.s3.amazonaws.com",
+	"media.githubusercontent.com",
+}
+
+// isLFSObjectURL 判断url是否指向LFS对象存储
+func isLFSObjectURL(rawURL string) bool {
+	for _, host := range lfsObjectHosts {
+		if strings.HasPrefix(rawURL, "https://"+host+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isLFSLocksURL 判断url是否为LFS文件锁api (info/lfs/locks), 其响应不含下载地址, 需原样透传
+func isLFSLocksURL(rawURL string) bool {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	return strings.Contains(rawURL, "/info/lfs/locks")
+}
+
+// isLFSJSON 判断响应是否为LFS batch api的json
+func isLFSJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/vnd.git-lfs+json")
+}
+
+// processLFSBatch 解析LFS batch响应, 仅改写 objects[].actions.*.href, 其余字段(包括header)保持不变
+func processLFSBatch(input io.Reader, compress string, host string, cfg *config.Config) ([]byte, error) {
+	var reader io.Reader = input
+	if compress == "gzip" {
+		gzipReader, err := gzip.NewReader(input)
+		if err != nil {
+			return nil, fmt.Errorf("gzip解压错误: %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader // 默认读取全部拼接的gzip member
+	}
+
+	// batch响应体较小, 限制读取大小防止异常响应占用过多内存
+	sizelimit := int64(cfg.Server.SizeLimit) * 1024 * 1024
+	decoder := json.NewDecoder(io.LimitReader(reader, sizelimit))
+	decoder.UseNumber() // 保持size等数值原样
+
+	var batch map[string]interface{}
+	if err := decoder.Decode(&batch); err != nil {
+		return nil, fmt.Errorf("invalid lfs batch response: %w", err)
+	}
+
+	objects, _ := batch["objects"].([]interface{})
+	for _, object := range objects {
+		obj, ok := object.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		actions, ok := obj["actions"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, action := range actions {
+			act, ok := action.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			href, ok := act["href"].(string)
+			if !ok {
+				continue
+			}
+			act["href"] = modifyLFSHref(href, host, cfg)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false) // 避免href中的 & 被转义
+	if err := encoder.Encode(batch); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// modifyLFSHref 将LFS对象地址改写为经过代理的地址
+func modifyLFSHref(href string, host string, cfg *config.Config) string {
+	if !isLFSObjectURL(href) && !strings.HasPrefix(href, "https://github.com/") {
+		return href
+	}
+	safeHost, ok := sanitizeRewriteHost(host, cfg)
+	if !ok {
+		logWarning("Untrusted rewrite host: %q, keep LFS href unchanged", host)
+		return href
+	}
+	modified := proxyURLPrefix(safeHost, cfg) + strings.TrimPrefix(href, "https://")
+	logDump("Modified LFS href: %s", modified)
+	return modified
+}
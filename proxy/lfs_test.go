@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"ghproxy/config"
+)
+
+func TestProcessLFSBatch(t *testing.T) {
+	const batch = `{"transfer":"basic","objects":[{"oid":"abc","size":12,"actions":{"download":{"href":"https://github-cloud.githubusercontent.com/alambic/media/1/abc?token=x&expires=1","header":{"Authorization":"RemoteAuth secret"},"expires_in":3600}}}]}`
+	const wantHref = "https://proxy.example/github-cloud.githubusercontent.com/alambic/media/1/abc?token=x&expires=1"
+	tests := []struct {
+		name     string
+		compress string
+		body     func(t *testing.T) []byte
+	}{
+		{name: "identity", body: func(t *testing.T) []byte { return []byte(batch) }},
+		{name: "gzip", compress: "gzip", body: func(t *testing.T) []byte { return gzipMembers(t, batch) }},
+		{name: "gzip two members", compress: "gzip", body: func(t *testing.T) []byte { return gzipMembers(t, batch[:40], batch[40:]) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := processLFSBatch(bytes.NewReader(tt.body(t)), tt.compress, "proxy.example", config.DefaultConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Objects []struct {
+					Size    json.Number `json:"size"`
+					Actions map[string]struct {
+						Href   string            `json:"href"`
+						Header map[string]string `json:"header"`
+					} `json:"actions"`
+				} `json:"objects"`
+			}
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("invalid output %q: %v", out, err)
+			}
+			if len(got.Objects) != 1 || got.Objects[0].Actions["download"].Href != wantHref {
+				t.Fatalf("output = %s, want download href %q", out, wantHref)
+			}
+			if header := got.Objects[0].Actions["download"].Header; header["Authorization"] != "RemoteAuth secret" {
+				t.Errorf("header = %v, want Authorization preserved", header)
+			}
+			if got.Objects[0].Size != "12" {
+				t.Errorf("size = %s, want 12", got.Objects[0].Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// 超长行截断时优先选择的分隔符, 尽量避免把url从中间切开
+const lineCutDelimiters = " \t'\"<>()"
+
+// limitedLineReader 按行读取, 单行超过max时分段返回
+type limitedLineReader struct {
+	r       *bufio.Reader
+	max     int // 单行最大长度, <=0 为不限制
+	pending []byte
+}
+
+func newLimitedLineReader(r *bufio.Reader, max int) *limitedLineReader {
+	return &limitedLineReader{r: r, max: max}
+}
+
+// ReadLine 返回包含结尾 \n 的一行; 超长时在最后一个分隔符处截断, 剩余部分留到下次返回
+// 读到结尾时, 先返回剩余数据, 再返回 io.EOF
+func (l *limitedLineReader) ReadLine() (string, error) {
+	buf := l.pending
+	l.pending = nil
+	// 上次截断剩余的部分中已包含完整的一行
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 && (l.max <= 0 || i < l.max) {
+		if i+1 < len(buf) {
+			l.pending = buf[i+1:]
+		}
+		return string(buf[:i+1]), nil
+	}
+	for {
+		if l.max > 0 && len(buf) >= l.max {
+			cut := bytes.LastIndexAny(buf[:l.max], lineCutDelimiters) + 1
+			if cut <= 0 {
+				cut = l.max
+			}
+			l.pending = append([]byte(nil), buf[cut:]...)
+			logDebug("Line exceeds maxLineBytes(%d), flushing %d bytes", l.max, cut)
+			return string(buf[:cut]), nil
+		}
+
+		chunk, err := l.r.ReadSlice('\n')
+		buf = append(buf, chunk...)
+		// 行长度超过max时回到循环开头截断
+		overflow := l.max > 0 && len(buf) > l.max
+		switch err {
+		case nil:
+			if overflow {
+				continue
+			}
+			return string(buf), nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if overflow {
+				continue
+			}
+			if len(buf) > 0 {
+				return string(buf), nil
+			}
+			return "", io.EOF
+		default:
+			return string(buf), err
+		}
+	}
+}
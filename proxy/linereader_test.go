@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLimitedLineReader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want []string
+	}{
+		{name: "unlimited", in: "a b c\nd\n", want: []string{"a b c\n", "d\n"}},
+		{name: "without trailing newline", in: "a\nb", max: 8, want: []string{"a\n", "b"}},
+		{name: "cut at delimiter", in: "aaaa bbbb cccc\n", max: 8, want: []string{"aaaa ", "bbbb ", "cccc\n"}},
+		{name: "cut at quote", in: `x="https://github.com/a" y` + "\n", max: 12, want: []string{`x="`, `https://gith`, `ub.com/a" y` + "\n"}},
+		{name: "no delimiter", in: "abcdefghij\n", max: 4, want: []string{"abcd", "efgh", "ij\n"}},
+		{name: "line of exactly max", in: "abc\nde\n", max: 4, want: []string{"abc\n", "de\n"}},
+		{name: "overflow at eof", in: "abcdef", max: 4, want: []string{"abcd", "ef"}},
+		{name: "empty", in: "", max: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 使用最小缓冲, 使长行经过 bufio.ErrBufferFull 分支
+			l := newLimitedLineReader(bufio.NewReaderSize(strings.NewReader(tt.in), 16), tt.max)
+			var got []string
+			for {
+				line, err := l.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, line)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"ghproxy/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WJQSERVER-STUDIO/logger"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// 测试中的日志写入临时目录, 仅保留错误级别; 错误页以纯文本返回, 不依赖嵌入的页面文件
+func TestMain(m *testing.M) {
+	SetErrorFormat("text")
+	dir, err := os.MkdirTemp("", "ghproxy-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := logger.Init(filepath.Join(dir, "test.log"), 5); err != nil {
+		panic(err)
+	}
+	if err := logger.SetLogLevel("error"); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// proxyTestConfig 返回直接连接测试上游的配置
+func proxyTestConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Upstream.BlockPrivateIPs = false
+	return cfg
+}
+
+// doChunkedProxy 以cfg初始化client后经 ChunkedProxyRequest 请求u, 返回响应状态码
+func doChunkedProxy(t *testing.T, cfg *config.Config, c *app.RequestContext, u string, matcher MatcherType) int {
+	t.Helper()
+	initHTTPClient(cfg)
+	setRedirectAllowedHosts(cfg)
+	t.Cleanup(func() {
+		initHTTPClient(config.DefaultConfig())
+		setRedirectAllowedHosts(config.DefaultConfig())
+	})
+	ChunkedProxyRequest(context.Background(), c, u, cfg, matcher)
+	return c.Response.StatusCode()
+}
+
+func newTestRequestContext(method string) *app.RequestContext {
+	c := app.NewContext(0)
+	c.Request.Header.SetMethod(method)
+	return c
+}
@@ -9,15 +9,115 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 )
 
-func Matcher(rawPath string, cfg *config.Config) (string, string, string, *GHProxyErrors) {
+// MatchResult 保存Matcher的匹配结果
+type MatchResult struct {
+	User    string      // 用户名
+	Repo    string      // 仓库名
+	Ref     string      // 分支/标签/commit, 未能提取时为空
+	GistID  string      // gist id, 仅gist匹配器
+	Path    string      // 仓库内的路径, 仅tree/blame匹配器
+	Tag     string      // release tag, 仅release下载链接
+	Asset   string      // release 资源文件名, 仅release下载链接
+	Format  string      // 归档格式(zip/tar.gz), 仅archive链接
+	Matcher MatcherType // 匹配器类型
+	URL     string      // 实际请求的上游url
+	Parsed  *url.URL    // 解析后的上游url, 解析失败时为nil
+}
+
+// Matcher 对rawPath进行分类
+// 出错时若已解析出user/repo, 仍返回仅包含这些字段的部分结果, 供日志与审计使用
+func Matcher(rawPath string, cfg *config.Config) (*MatchResult, *GHProxyErrors) {
+	result, errInfo := matchRawPath(rawPath, cfg)
+	if errInfo == nil {
+		result.parseURL()
+		GlobalStats.IncMatcher(string(result.Matcher))
+	}
+	return result, errInfo
+}
+
+// parseURL 仅解析一次上游url, 供后续流程复用; 解析失败时由调用方回退到字符串处理
+func (r *MatchResult) parseURL() {
+	parsedURL, err := url.Parse(r.URL)
+	if err != nil {
+		logDump("Failed to parse matched URL %s: %v", r.URL, err)
+		return
+	}
+	r.Parsed = parsedURL
+}
+
+// DiagnoseMatch 离线诊断url的匹配过程, 返回匹配结果、错误以及依次尝试过的分支
+// 不计入统计, 可用于构建命令行调试工具
+func DiagnoseMatch(rawPath string, cfg *config.Config) (MatchResult, *GHProxyErrors, []string) {
+	var trace matchTrace
+	result, errInfo := matchRawPathTraced(rawPath, cfg, &trace)
+	if result == nil {
+		return MatchResult{}, errInfo, trace
+	}
+	if errInfo == nil {
+		result.parseURL()
+	}
+	return *result, errInfo, trace
+}
+
+// containsControlChars 判断路径(及其百分号解码后的形式)是否包含控制字符
+func containsControlChars(rawPath string) bool {
+	hasControl := func(s string) bool {
+		return strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0
+	}
+	if hasControl(rawPath) {
+		return true
+	}
+	if strings.Contains(rawPath, "%") {
+		if decoded, err := url.PathUnescape(rawPath); err == nil {
+			return hasControl(decoded)
+		}
+	}
+	return false
+}
+
+// matchTrace 记录匹配过程中尝试过的分支, 为nil时不记录
+type matchTrace []string
+
+func (t *matchTrace) add(branch string) {
+	if t != nil {
+		*t = append(*t, branch)
+	}
+}
+
+func matchRawPath(rawPath string, cfg *config.Config) (*MatchResult, *GHProxyErrors) {
+	return matchRawPathTraced(rawPath, cfg, nil)
+}
+
+func matchRawPathTraced(rawPath string, cfg *config.Config, trace *matchTrace) (result *MatchResult, errInfo *GHProxyErrors) {
 	var (
 		user    string
 		repo    string
-		matcher string
+		matcher MatcherType
 	)
+	// 出错时保留已解析出的user/repo
+	defer func() {
+		// 各分支均应确定matcher, 未确定时不视为匹配成功, 避免以空matcher进入后续流程
+		if errInfo == nil && (result == nil || result.Matcher == "") {
+			result = nil
+			errInfo = NewErrorWithStatusLookup(400, "Url matched but no matcher was determined")
+		}
+		if errInfo != nil && result == nil && (user != "" || repo != "") {
+			result = &MatchResult{User: user, Repo: repo, Matcher: matcher}
+		}
+	}()
+	// 控制字符可能导致日志伪造或header注入, 在拆分前拒绝
+	if containsControlChars(rawPath) {
+		return nil, NewErrorWithStatusLookup(400, "URL contains control characters")
+	}
+	// 兼容省略scheme的路径, 如 github.com/user/repo/...
+	if !strings.HasPrefix(rawPath, "https://") && !strings.HasPrefix(rawPath, "http://") {
+		rawPath = "https://" + rawPath
+	}
 	// 匹配 "https://github.com"开头的链接
+	trace.add("github.com")
 	if strings.HasPrefix(rawPath, "https://github.com") {
 		remainingPath := strings.TrimPrefix(rawPath, "https://github.com")
 		if strings.HasPrefix(remainingPath, "/") {
@@ -26,82 +126,369 @@ func Matcher(rawPath string, cfg *config.Config) (string, string, string, *GHPro
 		// 预期格式/user/repo/more...
 		// 取出user和repo和最后部分
 		parts := strings.Split(remainingPath, "/")
+		// 旧版下载链接 /downloads/user/repo/file
+		if parts[0] == "downloads" {
+			if len(parts) <= 3 || parts[3] == "" {
+				errMsg := "Legacy downloads URL should have at least 4 parts (downloads/user/repo/file)."
+				return nil, NewErrorWithStatusLookup(400, errMsg)
+			}
+			return &MatchResult{User: parts[1], Repo: parts[2], Matcher: MatcherReleases, URL: rawPath}, nil
+		}
+		// 赞助页面 /sponsors/<user> 不属于仓库内容, 明确拒绝以区别于无效仓库
+		if parts[0] == "sponsors" {
+			if len(parts) < 2 || parts[1] == "" {
+				return nil, NewErrorWithStatusLookup(403, "GitHub Sponsors pages are not proxied")
+			}
+			return nil, NewErrorWithStatusLookup(403, fmt.Sprintf("GitHub Sponsors page of '%s' is not proxied, please visit https://github.com/sponsors/%s directly", parts[1], parts[1]))
+		}
+		// 账户级敏感页面, 如 /settings/... /notifications
+		if _, ok := sensitiveSubpaths[parts[0]]; ok {
+			return nil, NewErrorWithStatusLookup(403, fmt.Sprintf("Sensitive path '%s' is not allowed to be proxied", parts[0]))
+		}
+		// 不带info路径的 /user/repo.git, 部分工具会直接请求, 按clone原样透传
+		if len(parts) == 2 && strings.HasSuffix(parts[1], ".git") && parts[0] != "" {
+			repo = strings.TrimSuffix(parts[1], ".git")
+			if repo == "" {
+				return nil, NewErrorWithStatusLookup(400, "Invalid 'user/repo.git' format")
+			}
+			return &MatchResult{User: parts[0], Repo: repo, Matcher: MatcherClone, URL: rawPath}, nil
+		}
 		if len(parts) <= 2 {
 			errMsg := "Not enough parts in path after matching 'https://github.com*'"
-			return "", "", "", NewErrorWithStatusLookup(400, errMsg)
+			return nil, NewErrorWithStatusLookup(400, errMsg)
 		}
 		user = parts[0]
-		repo = parts[1]
-		// 匹配 "https://github.com"开头的链接
+		// clone地址 user/repo.git/info/refs 的repo带有.git后缀, 去除后用于黑白名单等检查, 上游url保持不变
+		repo = strings.TrimSuffix(parts[1], ".git")
+		// 匹配 "https://github.com"开头的链接, 上方已排除 len(parts) <= 2, 未命中的parts[2]均在default中返回错误
 		if len(parts) >= 3 {
-			switch parts[2] {
+			// /search?q=... 等路径的query直接跟在第三段之后, 分类时去除
+			section, _, _ := strings.Cut(parts[2], "?")
+			switch section {
 			case "releases", "archive":
-				matcher = "releases"
+				matcher = MatcherReleases
+				if section == "releases" {
+					tag, asset, errInfo := parseReleaseDownload(parts[3:])
+					if errInfo != nil {
+						return nil, errInfo
+					}
+					if asset != "" {
+						return &MatchResult{User: user, Repo: repo, Tag: tag, Asset: asset, Matcher: matcher, URL: rawPath}, nil
+					}
+				} else {
+					ref, format, errInfo := parseArchivePath(parts[3:])
+					if errInfo != nil {
+						return nil, errInfo
+					}
+					return &MatchResult{User: user, Repo: repo, Ref: ref, Format: format, Matcher: matcher, URL: rawPath}, nil
+				}
 			case "blob":
-				matcher = "blob"
+				matcher = MatcherBlob
+				// blob/...?raw=true 实际为原始文件, 按raw处理
+				if isBlobRawQuery(rawPath) {
+					matcher = MatcherRaw
+					rawPath = strings.Replace(rawPath, "/blob/", "/raw/", 1)
+				}
 			case "raw":
-				matcher = "raw"
-			case "info", "git-upload-pack":
-				matcher = "clone"
+				matcher = MatcherRaw
+			case "tree":
+				// 目录页面, 需要ref
+				if len(parts) <= 3 || parts[3] == "" {
+					errMsg := "Tree URL should have at least 4 parts (user/repo/tree/ref)."
+					return nil, NewErrorWithStatusLookup(400, errMsg)
+				}
+				matcher = MatcherTree
+			case "blame":
+				// blame页面为html, 需要ref与文件路径
+				if len(parts) <= 4 || parts[3] == "" {
+					errMsg := "Blame URL should have at least 5 parts (user/repo/blame/ref/file)."
+					return nil, NewErrorWithStatusLookup(400, errMsg)
+				}
+				matcher = MatcherBlame
+			case "find", "search":
+				// web UI 的文件查找器, 以json返回, 需开启 upstream.allowFileFinder
+				if !cfg.Upstream.AllowFileFinder {
+					errMsg := "Url Matched 'https://github.com*', but didn't match the next matcher"
+					return nil, NewErrorWithStatusLookup(400, errMsg)
+				}
+				if section == "find" && (len(parts) <= 3 || parts[3] == "") {
+					errMsg := "Find URL should have at least 4 parts (user/repo/find/ref)."
+					return nil, NewErrorWithStatusLookup(400, errMsg)
+				}
+				matcher = MatcherFind
+			case "info", "git-upload-pack", "objects", "HEAD":
+				// objects/ 与 HEAD 为dumb HTTP协议的松散对象、info/packs 与pack文件, 原样透传
+				matcher = MatcherClone
+				// LFS batch api: /user/repo.git/info/lfs/...
+				if len(parts) >= 4 && section == "info" && parts[3] == "lfs" {
+					matcher = MatcherLFS
+				}
 			default:
+				// 仓库级敏感页面, 如 /user/repo/security/advisories
+				if _, ok := sensitiveSubpaths[section]; ok {
+					return nil, NewErrorWithStatusLookup(403, fmt.Sprintf("Sensitive path '%s' is not allowed to be proxied", section))
+				}
 				errMsg := "Url Matched 'https://github.com*', but didn't match the next matcher"
-				return "", "", "", NewErrorWithStatusLookup(400, errMsg)
+				return nil, NewErrorWithStatusLookup(400, errMsg)
 			}
 		}
-		return user, repo, matcher, nil
+		var ref, subPath string
+		if matcher == MatcherTree || matcher == MatcherBlame {
+			ref, subPath = splitRefPath(parts[3:])
+			if matcher == MatcherBlame && subPath == "" {
+				errMsg := "Blame URL should have at least 5 parts (user/repo/blame/ref/file)."
+				return nil, NewErrorWithStatusLookup(400, errMsg)
+			}
+		} else if (matcher == MatcherBlob || matcher == MatcherRaw) && len(parts) >= 4 {
+			// 支持 /raw/refs/heads/<branch>/<file> 形式, 由 splitRefPath 提取完整 ref
+			ref, _ = splitRefPath(parts[3:])
+			if resolved := resolveRef(ref, cfg); resolved != ref {
+				ref = resolved
+				rawPath = replaceURLSegment(rawPath, 4, ref)
+			}
+		} else if matcher == MatcherFind && len(parts) >= 4 {
+			ref, _, _ = strings.Cut(parts[3], "?")
+		}
+		return &MatchResult{User: user, Repo: repo, Ref: ref, Path: subPath, Matcher: matcher, URL: rawPath}, nil
 	}
 	// 匹配 "https://raw"开头的链接
+	trace.add("raw")
 	if strings.HasPrefix(rawPath, "https://raw") {
+		rawPath = normalizeLegacyRawURL(rawPath)
 		remainingPath := strings.TrimPrefix(rawPath, "https://")
 		parts := strings.Split(remainingPath, "/")
+		// gist单文件: raw.githubusercontent.com/gist/user/gist_id/...
+		if len(parts) >= 2 && parts[0] == "raw.githubusercontent.com" && parts[1] == "gist" {
+			if len(parts) <= 3 || parts[2] == "" || parts[3] == "" {
+				errMsg := "URL after matched 'https://raw.githubusercontent.com/gist*' should have at least 3 parts (gist/user/gist_id)."
+				return nil, NewErrorWithStatusLookup(400, errMsg)
+			}
+			return &MatchResult{User: parts[2], GistID: parts[3], Matcher: MatcherGist, URL: rawPath}, nil
+		}
 		if len(parts) <= 3 {
 			errMsg := "URL after matched 'https://raw*' should have at least 4 parts (user/repo/branch/file)."
-			return "", "", "", NewErrorWithStatusLookup(400, errMsg)
+			return nil, NewErrorWithStatusLookup(400, errMsg)
 		}
 		user = parts[1]
 		repo = parts[2]
-		matcher = "raw"
+		matcher = MatcherRaw
+
+		// 兼容 user/repo@ref/file 形式, @后为ref, 其后均为文件路径
+		if idx := strings.Index(repo, "@"); idx >= 0 {
+			ref := repo[idx+1:]
+			repo = repo[:idx]
+			if repo == "" || ref == "" {
+				errMsg := "Invalid 'user/repo@ref/file' format"
+				return nil, NewErrorWithStatusLookup(400, errMsg)
+			}
+			ref = resolveRef(ref, cfg)
+			filePath := strings.Join(parts[3:], "/")
+			return &MatchResult{User: user, Repo: repo, Ref: ref, Matcher: matcher, URL: BuildUpstreamURL(user, repo, ref, filePath)}, nil
+		}
 
-		return user, repo, matcher, nil
+		// github.com/.../raw/refs/heads/<branch>/<file> 会被重定向至 user/repo/refs/heads/<branch>/<file>
+		ref, _ := splitRefPath(parts[3:])
+		if resolved := resolveRef(ref, cfg); resolved != ref {
+			ref = resolved
+			rawPath = replaceURLSegment(rawPath, 3, ref)
+		}
+		return &MatchResult{User: user, Repo: repo, Ref: ref, Matcher: matcher, URL: rawPath}, nil
+	}
+	// 匹配 "https://gist.github.com/user/id.js" 嵌入脚本
+	trace.add("gist.github.com")
+	// 仅处理 gist.github.com/user/gist_id.js 形式的嵌入脚本, html页面等其余形式交由下方gist分支
+	if strings.HasPrefix(rawPath, "https://gist.github.com/") {
+		remainingPath := strings.TrimPrefix(rawPath, "https://")
+		parts := strings.Split(remainingPath, "/")
+		if len(parts) == 3 && parts[1] != "" {
+			embed, _, _ := strings.Cut(parts[2], "?")
+			// 去除 .js 后缀得到gist id, url本身保持不变
+			if gistID, ok := strings.CutSuffix(embed, ".js"); ok && gistID != "" {
+				user = parts[1]
+				repo = ""
+				matcher = MatcherGist
+				return &MatchResult{User: user, Repo: repo, GistID: gistID, Matcher: matcher, URL: rawPath}, nil
+			}
+		}
 	}
 	// 匹配 "https://gist"开头的链接
+	trace.add("gist")
 	if strings.HasPrefix(rawPath, "https://gist") {
 		remainingPath := strings.TrimPrefix(rawPath, "https://")
 		parts := strings.Split(remainingPath, "/")
 		if len(parts) <= 3 {
 			errMsg := "URL after matched 'https://gist*' should have at least 4 parts (user/gist_id)."
-			return "", "", "", NewErrorWithStatusLookup(400, errMsg)
+			return nil, NewErrorWithStatusLookup(400, errMsg)
 		}
 		user = parts[1]
 		repo = ""
-		matcher = "gist"
-		return user, repo, matcher, nil
+		matcher = MatcherGist
+		return &MatchResult{User: user, Repo: repo, GistID: parts[2], Matcher: matcher, URL: rawPath}, nil
 	}
 	// 匹配 "https://api.github.com/"开头的链接
+	trace.add("api.github.com")
 	if strings.HasPrefix(rawPath, "https://api.github.com/") {
-		matcher = "api"
+		matcher = MatcherAPI
 		remainingPath := strings.TrimPrefix(rawPath, "https://api.github.com/")
+		remainingPath, _, _ = strings.Cut(remainingPath, "?")
 
+		// /repos 与 /users 等不带后续路径的请求不含user/repo
 		parts := strings.Split(remainingPath, "/")
-		if parts[0] == "repos" {
+		if (parts[0] == "repos" || parts[0] == "users") && len(parts) >= 2 {
 			user = parts[1]
+		}
+		if parts[0] == "repos" && len(parts) >= 3 {
 			repo = parts[2]
 		}
-		if parts[0] == "users" {
-			user = parts[1]
+		// api鉴权由Authorizer在分类后处理
+		return &MatchResult{User: user, Repo: repo, Matcher: matcher, URL: rawPath}, nil
+	}
+	// 匹配 GitHub Packages 仓库
+	trace.add("packages")
+	if cfg.Upstream.AllowPackages {
+		if strings.HasPrefix(rawPath, "https://npm.pkg.github.com/") || strings.HasPrefix(rawPath, "https://maven.pkg.github.com/") {
+			return matchPackagesPath(rawPath)
 		}
-		if !cfg.Auth.ForceAllowApi {
-			if cfg.Auth.Method != "header" || !cfg.Auth.Enabled {
-				//return "", "", "", ErrAuthHeaderUnavailable
-				errMsg := "AuthHeader Unavailable, Need to open header auth to enable api proxy"
-				return "", "", "", NewErrorWithStatusLookup(403, errMsg)
-			}
+	}
+	// 匹配 issue/评论中上传的图片
+	trace.add("user-images.githubusercontent.com")
+	if strings.HasPrefix(rawPath, "https://user-images.githubusercontent.com/") {
+		remainingPath := strings.TrimPrefix(rawPath, "https://")
+		parts := strings.Split(remainingPath, "/")
+		if len(parts) <= 2 || parts[2] == "" {
+			errMsg := "URL after matched 'https://user-images.githubusercontent.com*' should have at least 2 parts (user_id/file)."
+			return nil, NewErrorWithStatusLookup(400, errMsg)
 		}
-		return user, repo, matcher, nil
+		return &MatchResult{User: parts[1], Matcher: MatcherRaw, URL: rawPath}, nil
+	}
+	// 匹配 release 资源重定向后的对象存储链接
+	// 查询参数带有签名(X-Amz-*), url需原样转发, 不做任何改写
+	trace.add("objects.githubusercontent.com")
+	if strings.HasPrefix(rawPath, "https://objects.githubusercontent.com/") {
+		return &MatchResult{Matcher: MatcherObject, URL: rawPath}, nil
+	}
+	// 匹配 archive 重定向后的 codeload 链接, 如 codeload.github.com/user/repo/tar.gz/refs/heads/main
+	trace.add("codeload.github.com")
+	if strings.HasPrefix(rawPath, "https://codeload.github.com/") {
+		remainingPath := strings.TrimPrefix(rawPath, "https://codeload.github.com/")
+		if i := strings.IndexAny(remainingPath, "?#"); i >= 0 {
+			remainingPath = remainingPath[:i]
+		}
+		parts := strings.SplitN(remainingPath, "/", 4)
+		if len(parts) < 4 || parts[0] == "" || parts[1] == "" || parts[3] == "" {
+			errMsg := "URL after matched 'https://codeload.github.com*' should have at least 4 parts (user/repo/format/ref)."
+			return nil, NewErrorWithStatusLookup(400, errMsg)
+		}
+		format := strings.TrimPrefix(parts[2], "legacy.")
+		if format != "zip" && format != "tar.gz" {
+			return nil, NewErrorWithStatusLookup(400, fmt.Sprintf("Unsupported archive format '%s'", parts[2]))
+		}
+		return &MatchResult{User: parts[0], Repo: parts[1], Ref: parts[3], Format: format, Matcher: MatcherReleases, URL: rawPath}, nil
+	}
+	// 匹配 LFS 对象存储链接
+	trace.add("lfs")
+	if isLFSObjectURL(rawPath) {
+		return &MatchResult{Matcher: MatcherLFS, URL: rawPath}, nil
+	}
+	// 匹配 jsDelivr 风格的 "https://gh/user/repo@ref/file" 路径
+	trace.add("cdn")
+	if cfg.Shell.EnableCDNPaths && strings.HasPrefix(rawPath, "https://gh/") {
+		return matchCDNPath(strings.TrimPrefix(rawPath, "https://gh/"), cfg)
 	}
 	//return "", "", "", ErrNotFound
 	errMsg := "Didn't match any matcher"
-	return "", "", "", NewErrorWithStatusLookup(404, errMsg)
+	return nil, NewErrorWithStatusLookup(404, errMsg)
+}
+
+// matchPackagesPath 解析 npm(/@owner/pkg) 与 maven(/owner/repo/...) 路径中的owner
+func matchPackagesPath(rawPath string) (*MatchResult, *GHProxyErrors) {
+	remainingPath := strings.TrimPrefix(rawPath, "https://")
+	parts := strings.Split(remainingPath, "/")
+	if len(parts) < 2 || parts[1] == "" {
+		errMsg := "URL after matched GitHub Packages host should contain the package owner."
+		return nil, NewErrorWithStatusLookup(400, errMsg)
+	}
+	var user, repo string
+	if strings.HasPrefix(parts[0], "npm.") {
+		// npm scope可能被编码为 @owner%2fpkg
+		scope := strings.TrimPrefix(parts[1], "@")
+		if idx := strings.Index(strings.ToLower(scope), "%2f"); idx >= 0 {
+			user = scope[:idx]
+			repo = scope[idx+3:]
+		} else {
+			user = scope
+			if len(parts) >= 3 {
+				repo = parts[2]
+			}
+		}
+	} else {
+		user = parts[1]
+		if len(parts) >= 3 {
+			repo = parts[2]
+		}
+	}
+	return &MatchResult{User: user, Repo: repo, Matcher: MatcherPackages, URL: rawPath}, nil
+}
+
+// matchCDNPath 解析 user/repo@ref/file 格式, 转换为 raw.githubusercontent.com 请求
+func matchCDNPath(remainingPath string, cfg *config.Config) (*MatchResult, *GHProxyErrors) {
+	parts := strings.SplitN(remainingPath, "/", 3)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		errMsg := "URL after matched '/gh/*' should have at least 3 parts (user/repo@ref/file)."
+		return nil, NewErrorWithStatusLookup(400, errMsg)
+	}
+	user := parts[0]
+	repo := parts[1]
+	ref := ""
+	// 未指定@ref时使用默认分支
+	if idx := strings.Index(repo, "@"); idx >= 0 {
+		ref = repo[idx+1:]
+		repo = repo[:idx]
+		if repo == "" || ref == "" {
+			errMsg := "Invalid '/gh/user/repo@ref' format"
+			return nil, NewErrorWithStatusLookup(400, errMsg)
+		}
+	}
+	ref = resolveRef(ref, cfg)
+	return &MatchResult{
+		User:    user,
+		Repo:    repo,
+		Ref:     ref,
+		Matcher: MatcherRaw,
+		URL:     BuildUpstreamURL(user, repo, ref, parts[2]),
+	}, nil
+}
+
+// BuildUpstreamURL 由user/repo/ref/文件路径构建raw.githubusercontent.com的上游url
+// ref为空时使用默认分支(HEAD)
+func BuildUpstreamURL(user, repo, ref, filePath string) string {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return "https://raw.githubusercontent.com/" + user + "/" + repo + "/" + ref + "/" + strings.TrimPrefix(filePath, "/")
+}
+
+// resolveRef ref为空或HEAD且配置了upstream.defaultBranch时, 替换为默认分支
+func resolveRef(ref string, cfg *config.Config) string {
+	if cfg.Upstream.DefaultBranch != "" && (ref == "" || ref == "HEAD") {
+		return cfg.Upstream.DefaultBranch
+	}
+	return ref
+}
+
+// replaceURLSegment 替换url中(scheme之后)按"/"分割的第index段
+func replaceURLSegment(rawURL string, index int, value string) string {
+	prefix := ""
+	if idx := strings.Index(rawURL, "://"); idx >= 0 {
+		prefix = rawURL[:idx+3]
+		rawURL = rawURL[idx+3:]
+	}
+	segments := strings.Split(rawURL, "/")
+	if index < 0 || index >= len(segments) {
+		return prefix + rawURL
+	}
+	segments[index] = value
+	return prefix + strings.Join(segments, "/")
 }
 
 func EditorMatcher(rawPath string, cfg *config.Config) (bool, error) {
@@ -125,6 +512,14 @@ func EditorMatcher(rawPath string, cfg *config.Config) (bool, error) {
 	if strings.HasPrefix(rawPath, "https://gist.github.com") {
 		return true, nil
 	}
+	// 匹配 "https://user-images.githubusercontent.com"开头的链接
+	if strings.HasPrefix(rawPath, "https://user-images.githubusercontent.com") {
+		return true, nil
+	}
+	// 匹配 "https://codeload.github.com"开头的链接
+	if strings.HasPrefix(rawPath, "https://codeload.github.com") {
+		return true, nil
+	}
 	if cfg.Shell.RewriteAPI {
 		// 匹配 "https://api.github.com/"开头的链接
 		if strings.HasPrefix(rawPath, "https://api.github.com") {
@@ -134,16 +529,191 @@ func EditorMatcher(rawPath string, cfg *config.Config) (bool, error) {
 	return false, nil
 }
 
+// BulkEditorMatch 批量返回各url是否会被改写, 便于审计文档中的链接覆盖情况; EditorMatcher出错的url视为不改写
+func BulkEditorMatch(urls []string, cfg *config.Config) map[string]bool {
+	decisions := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		matched, err := EditorMatcher(u, cfg)
+		decisions[u] = matched && err == nil
+	}
+	return decisions
+}
+
 // 匹配文件扩展名是sh的rawPath
 func MatcherShell(rawPath string) bool {
 	return strings.HasSuffix(rawPath, ".sh")
 }
 
+// 不允许代理的github.com敏感子路径
+var sensitiveSubpaths = map[string]struct{}{
+	"security":      {},
+	"settings":      {},
+	"notifications": {},
+	"invitations":   {},
+	"sessions":      {},
+	"login":         {},
+	"logout":        {},
+}
+
+// parseReleaseDownload 解析 releases/ 之后的路径
+// download/<tag>/<asset> 与 latest/download/<asset> 返回tag与资源名, 结构不完整时返回400
+// 其他release页面(如 releases/tag/xxx)返回空资源名
+func parseReleaseDownload(segments []string) (string, string, *GHProxyErrors) {
+	if len(segments) > 0 {
+		// 不修改调用方的切片
+		segments = append([]string(nil), segments...)
+		segments[len(segments)-1] = strings.SplitN(segments[len(segments)-1], "?", 2)[0]
+	}
+	switch {
+	case len(segments) >= 1 && segments[0] == "download":
+		if len(segments) != 3 || segments[1] == "" || segments[2] == "" {
+			return "", "", NewErrorWithStatusLookup(400, "Release download URL should be releases/download/<tag>/<asset>")
+		}
+		return segments[1], segments[2], nil
+	case len(segments) >= 2 && segments[0] == "latest" && segments[1] == "download":
+		if len(segments) != 3 || segments[2] == "" {
+			return "", "", NewErrorWithStatusLookup(400, "Release download URL should be releases/latest/download/<asset>")
+		}
+		return "latest", segments[2], nil
+	}
+	return "", "", nil
+}
+
+// archiveFormats archive链接支持的归档格式后缀
+var archiveFormats = []string{".tar.gz", ".zip"}
+
+// parseArchivePath 解析 archive/ 之后的路径, 如 refs/tags/<tag>.tar.gz、refs/heads/<branch>.zip、<ref>.zip
+// 返回ref与归档格式, 结构不完整或格式不支持时返回400
+func parseArchivePath(segments []string) (string, string, *GHProxyErrors) {
+	joined := strings.Join(segments, "/")
+	if i := strings.IndexAny(joined, "?#"); i >= 0 {
+		joined = joined[:i]
+	}
+	for _, suffix := range archiveFormats {
+		if !strings.HasSuffix(joined, suffix) {
+			continue
+		}
+		ref := strings.TrimSuffix(joined, suffix)
+		refSegments := strings.Split(ref, "/")
+		if ref == "" || strings.HasSuffix(ref, "/") ||
+			(refSegments[0] == "refs" && (len(refSegments) < 3 || (refSegments[1] != "heads" && refSegments[1] != "tags"))) {
+			break
+		}
+		return ref, strings.TrimPrefix(suffix, "."), nil
+	}
+	return "", "", NewErrorWithStatusLookup(400, "Archive URL should be archive/<ref>.zip or archive/<ref>.tar.gz (ref may be refs/heads/<branch> or refs/tags/<tag>)")
+}
+
+// splitRefPath 从 ref/path... 中拆分出ref与仓库内路径
+// refs/heads/xxx 与 refs/tags/xxx 形式的ref占三段, 其余取第一段
+func splitRefPath(segments []string) (string, string) {
+	joined := strings.Join(segments, "/")
+	if i := strings.IndexAny(joined, "?#"); i >= 0 {
+		joined = joined[:i]
+	}
+	segments = strings.Split(joined, "/")
+	n := 1
+	if len(segments) >= 3 && segments[0] == "refs" && (segments[1] == "heads" || segments[1] == "tags") {
+		n = 3
+	}
+	if len(segments) < n {
+		n = len(segments)
+	}
+	return strings.Join(segments[:n], "/"), strings.Join(segments[n:], "/")
+}
+
+// isCommitSHA 判断ref是否为完整的commit SHA (sha1为40位, sha256为64位)
+func isCommitSHA(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
+	}
+	for i := 0; i < len(ref); i++ {
+		ch := ref[i]
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheControlFor 根据ref类型返回 Cache-Control, 固定SHA的内容不可变可长期缓存
+func cacheControlFor(ref string, cfg *config.Config) string {
+	if isCommitSHA(ref) {
+		return fmt.Sprintf("public, max-age=%d, immutable", cfg.Shell.CacheControl.ShaMaxAge)
+	}
+	if cfg.Shell.CacheControl.RefValue != "" {
+		return cfg.Shell.CacheControl.RefValue
+	}
+	return "no-cache"
+}
+
+// isBlobRawQuery 判断blob链接是否带有 ?raw=true 参数
+func isBlobRawQuery(rawPath string) bool {
+	i := strings.Index(rawPath, "?")
+	if i < 0 {
+		return false
+	}
+	query, err := url.ParseQuery(rawPath[i+1:])
+	return err == nil && query.Get("raw") == "true"
+}
+
+// contentTypeOverride 按扩展名查找 ContentTypeOverrides 中配置的 Content-Type
+// 匹配前去除query与fragment, 多个扩展名同时匹配时(如 .sh 与 .tar.sh)取最长者, 保证结果稳定
+func contentTypeOverride(rawPath string, cfg *config.Config) (string, bool) {
+	if i := strings.IndexAny(rawPath, "?#"); i >= 0 {
+		rawPath = rawPath[:i]
+	}
+	var matched, key string
+	for ext := range cfg.Shell.ContentTypeOverrides {
+		suffix := ext
+		if !strings.HasPrefix(suffix, ".") {
+			suffix = "." + suffix
+		}
+		if !strings.HasSuffix(rawPath, suffix) {
+			continue
+		}
+		// 长度相同时按配置键排序, 避免 "sh" 与 ".sh" 并存时取决于map遍历顺序
+		if len(suffix) > len(matched) || (len(suffix) == len(matched) && ext < key) {
+			matched, key = suffix, ext
+		}
+	}
+	if matched == "" {
+		return "", false
+	}
+	return cfg.Shell.ContentTypeOverrides[key], true
+}
+
+// 匹配release页面懒加载资源列表的路径
+func isExpandedAssets(rawPath string) bool {
+	return strings.Contains(rawPath, "/releases/expanded_assets/")
+}
+
 // LinkProcessor 是一个函数类型，用于处理提取到的链接。
 type LinkProcessor func(string) string
 
 // 自定义 URL 修改函数
 func modifyURL(url string, host string, cfg *config.Config) string {
+	// HTTPS://GITHUB.COM/... 等形式统一为小写scheme与host后再匹配, 未被改写时保持原样
+	if normalized := lowerSchemeHost(url); normalized != url {
+		if modified := modifyURL(normalized, host, cfg); modified != normalized {
+			return modified
+		}
+		return url
+	}
+	// git:// 协议无法经过代理, 转换为https形式后改写
+	if strings.HasPrefix(url, "git://github.com/") {
+		modified := modifyURL("https://"+strings.TrimPrefix(url, "git://"), host, cfg)
+		if strings.HasPrefix(modified, "https://github.com/") {
+			return url // 未被改写时保持原样
+		}
+		return modified
+	}
+	// 已经指向本代理的链接不再重复改写
+	if cfg.Shell.PreventDoubleProxy {
+		if _, proxied := unwrapProxyURL(url, host, cfg); proxied {
+			return url
+		}
+	}
 	// 去除url内的https://或http://
 	matched, err := EditorMatcher(url, cfg)
 	if err != nil {
@@ -151,20 +721,272 @@ func modifyURL(url string, host string, cfg *config.Config) string {
 		return url
 	}
 	if matched {
-		var u = url
-		u = strings.TrimPrefix(u, "https://")
-		u = strings.TrimPrefix(u, "http://")
-		logDump("Modified URL: %s", "https://"+host+"/"+u)
-		return "https://" + host + "/" + u
+		safeHost, ok := sanitizeRewriteHost(host, cfg)
+		if !ok {
+			logWarning("Untrusted rewrite host: %q, keep URL unchanged", host)
+			return url
+		}
+		host = safeHost
+		var u = stripQueryParams(applyHostAlias(normalizeLegacyRawURL(url), cfg), cfg.Shell.StripQueryParams)
+		// 配置开启时省略scheme, 输出 https://host/github.com/...
+		if cfg.Shell.OmitSchemeInRewrite {
+			u = strings.TrimPrefix(u, "https://")
+			u = strings.TrimPrefix(u, "http://")
+		}
+		logDump("Modified URL: %s", proxyURLPrefix(host, cfg)+u)
+		return proxyURLPrefix(host, cfg) + u
 	}
 	return url
 }
 
+// lowerSchemeHost 将url的scheme与host转为小写, path与查询参数保持不变
+func lowerSchemeHost(rawURL string) string {
+	schemeEnd := strings.Index(rawURL, "://")
+	if schemeEnd < 0 {
+		return rawURL
+	}
+	rest := rawURL[schemeEnd+3:]
+	hostEnd := strings.IndexAny(rest, "/?#")
+	if hostEnd < 0 {
+		hostEnd = len(rest)
+	}
+	return strings.ToLower(rawURL[:schemeEnd+3]) + strings.ToLower(rest[:hostEnd]) + rest[hostEnd:]
+}
+
+// normalizeLegacyRawURL 将旧版 raw.github.com/user/repo/branch/file 链接转换为 raw.githubusercontent.com 形式
+func normalizeLegacyRawURL(rawURL string) string {
+	for _, prefix := range []string{"https://raw.github.com/", "http://raw.github.com/"} {
+		if strings.HasPrefix(rawURL, prefix) {
+			return "https://raw.githubusercontent.com/" + rawURL[len(prefix):]
+		}
+	}
+	return rawURL
+}
+
+// applyHostAlias 按 HostAliases 将链接的host替换为规范host, 如 raw.github.com -> raw.githubusercontent.com
+func applyHostAlias(rawURL string, cfg *config.Config) string {
+	if len(cfg.Shell.HostAliases) == 0 {
+		return rawURL
+	}
+	for _, scheme := range []string{"https://", "http://"} {
+		if !strings.HasPrefix(rawURL, scheme) {
+			continue
+		}
+		rest := rawURL[len(scheme):]
+		hostEnd := strings.IndexAny(rest, "/?#")
+		if hostEnd < 0 {
+			hostEnd = len(rest)
+		}
+		for alias, canonical := range cfg.Shell.HostAliases {
+			if strings.EqualFold(rest[:hostEnd], alias) {
+				return scheme + canonical + rest[hostEnd:]
+			}
+		}
+	}
+	return rawURL
+}
+
+// 改写时始终保留的查询参数
+var essentialQueryParams = map[string]struct{}{
+	"token": {},
+}
+
+// stripQueryParams 移除链接中匹配的查询参数(如 utm_*), 其余参数保持原有顺序与编码
+func stripQueryParams(rawURL string, patterns []string) string {
+	if len(patterns) == 0 {
+		return rawURL
+	}
+	qStart := strings.Index(rawURL, "?")
+	if qStart < 0 {
+		return rawURL
+	}
+	base, query, fragment := rawURL[:qStart], rawURL[qStart+1:], ""
+	if i := strings.Index(query, "#"); i >= 0 {
+		query, fragment = query[:i], query[i:]
+	}
+	kept := make([]string, 0, strings.Count(query, "&")+1)
+	for _, param := range strings.Split(query, "&") {
+		key := strings.SplitN(param, "=", 2)[0]
+		if _, essential := essentialQueryParams[key]; !essential && headerPatternsMatch(patterns, key) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	if len(kept) == 0 {
+		return base + fragment
+	}
+	return base + "?" + strings.Join(kept, "&") + fragment
+}
+
+// 跳过改写的路径正则, 启动时由 compileSkipRewritePatterns 编译
+var skipRewritePatterns []*regexp.Regexp
+
+// compileSkipRewritePatterns 编译 config.Shell.SkipRewritePatterns
+func compileSkipRewritePatterns(cfg *config.Config) error {
+	patterns := make([]*regexp.Regexp, 0, len(cfg.Shell.SkipRewritePatterns))
+	for _, pattern := range cfg.Shell.SkipRewritePatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid shell.skipRewritePatterns '%s': %w", pattern, err)
+		}
+		patterns = append(patterns, compiled)
+	}
+	skipRewritePatterns = patterns
+	return nil
+}
+
+// skipRewrite 判断上游url的路径是否匹配跳过改写的正则, 如压缩后的 .min.js
+func skipRewrite(rawURL string) bool {
+	if len(skipRewritePatterns) == 0 {
+		return false
+	}
+	path := rawURL
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if i := strings.Index(path, "://"); i >= 0 {
+		path = path[i+3:]
+	}
+	if i := strings.Index(path, "/"); i >= 0 {
+		path = path[i:]
+	}
+	for _, pattern := range skipRewritePatterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawOverrideParam 单次请求要求原样透传(跳过改写)的查询参数
+const rawOverrideParam = "ghproxy_raw"
+
+// popRawOverride 移除url中的 ghproxy_raw 参数, 值为1或true时要求原样透传
+func popRawOverride(rawURL string) (string, bool) {
+	qStart := strings.Index(rawURL, "?")
+	if qStart < 0 {
+		return rawURL, false
+	}
+	query := rawURL[qStart+1:]
+	if i := strings.Index(query, "#"); i >= 0 {
+		query = query[:i]
+	}
+	found, override := false, false
+	for _, param := range strings.Split(query, "&") {
+		key, value, _ := strings.Cut(param, "=")
+		if key == rawOverrideParam {
+			found = true
+			override = value == "1" || value == "true"
+		}
+	}
+	if !found {
+		return rawURL, false
+	}
+	return stripQueryParams(rawURL, []string{rawOverrideParam}), override
+}
+
+// sanitizeRewriteHost 校验用于改写链接的host
+// 不受信任或格式非法时回退到 CanonicalHost, 无可用host时返回false
+func sanitizeRewriteHost(host string, cfg *config.Config) (string, bool) {
+	fallback := cfg.Server.CanonicalHost
+	if fallback == "" && len(cfg.Server.TrustedHosts) > 0 {
+		fallback = cfg.Server.TrustedHosts[0]
+	}
+
+	if host == "" || strings.ContainsAny(host, "/\\@?#% \t\r\n") {
+		return fallback, fallback != ""
+	}
+
+	if len(cfg.Server.TrustedHosts) == 0 {
+		if cfg.Server.CanonicalHost != "" && !strings.EqualFold(host, cfg.Server.CanonicalHost) {
+			return cfg.Server.CanonicalHost, true
+		}
+		return host, true
+	}
+	for _, trusted := range cfg.Server.TrustedHosts {
+		if strings.EqualFold(host, trusted) {
+			return host, true
+		}
+	}
+	return fallback, true
+}
+
+// proxyURLPrefix 返回改写后链接的前缀, 子路径部署时带上 PathPrefix
+func proxyURLPrefix(host string, cfg *config.Config) string {
+	prefix := strings.Trim(cfg.Server.PathPrefix, "/")
+	if prefix == "" {
+		return "https://" + host + "/"
+	}
+	return "https://" + host + "/" + prefix + "/"
+}
+
+// proxyHosts 返回视为本代理的host列表
+func proxyHosts(host string, cfg *config.Config) []string {
+	hosts := make([]string, 0, len(cfg.Server.TrustedHosts)+2)
+	if host != "" {
+		hosts = append(hosts, host)
+	}
+	if cfg.Server.CanonicalHost != "" {
+		hosts = append(hosts, cfg.Server.CanonicalHost)
+	}
+	return append(hosts, cfg.Server.TrustedHosts...)
+}
+
+// unwrapProxyURL 去除链接中指向本代理的前缀(可多层), 返回去除后的链接及是否去除过
+func unwrapProxyURL(rawURL string, host string, cfg *config.Config) (string, bool) {
+	hosts := proxyHosts(host, cfg)
+	unwrapped := false
+	for {
+		trimmed := false
+		for _, h := range hosts {
+			for _, scheme := range []string{"https://", "http://"} {
+				p := scheme + h + "/"
+				if len(rawURL) >= len(p) && strings.EqualFold(rawURL[:len(p)], p) {
+					rawURL = stripPathPrefix(rawURL[len(p):], cfg)
+					trimmed = true
+					break
+				}
+			}
+			if trimmed {
+				break
+			}
+		}
+		if !trimmed {
+			return rawURL, unwrapped
+		}
+		unwrapped = true
+	}
+}
+
+// canonicalURL 去除url路径部分的尾部斜杠, query保持不变
+func canonicalURL(rawURL string) string {
+	path, query, hasQuery := strings.Cut(rawURL, "?")
+	if strings.Count(path, "/") > 3 {
+		path = strings.TrimRight(path, "/")
+	}
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
+
+// stripPathPrefix 去除子路径部署时的 PathPrefix
+func stripPathPrefix(rawPath string, cfg *config.Config) string {
+	prefix := strings.Trim(cfg.Server.PathPrefix, "/")
+	if prefix == "" {
+		return rawPath
+	}
+	if rawPath == prefix {
+		return ""
+	}
+	return strings.TrimPrefix(rawPath, prefix+"/")
+}
+
 var (
-	matchedMatchers = []string{
-		"blob",
-		"raw",
-		"gist",
+	matchedMatchers = []MatcherType{
+		MatcherBlob,
+		MatcherRaw,
+		MatcherGist,
 	}
 )
 
@@ -210,14 +1032,84 @@ func extractParts(rawURL string) (string, string, string, url.Values, error) {
 	return repoOwner, repoName, remainingPath, queryParams, nil
 }
 
-var urlPattern = regexp.MustCompile(`https?://[^\s'"]+`)
+// scheme部分大小写不敏感, 以匹配 HTTPS:// 等形式; path保持大小写敏感
+var urlPattern = regexp.MustCompile(`(?i:https?|git)://[^\s'"]+`)
+
+// trimUnbalancedParen 返回url中第一个未配对的 ")" 之前的长度
+// 用于处理 markdown 的 ![alt](url) 与 [![alt](url)](url) 语法
+func trimUnbalancedParen(u string) int {
+	depth := 0
+	for i := 0; i < len(u); i++ {
+		switch u[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(u)
+}
+
+// rewriteURLs 替换行内所有匹配 urlPattern 的链接
+// 链接在未配对的 ")" 处截断, 剩余部分继续匹配, 保证括号平衡
+func rewriteURLs(line string, rewrite LinkProcessor) string {
+	var sb strings.Builder
+	for {
+		loc := urlPattern.FindStringIndex(line)
+		if loc == nil {
+			sb.WriteString(line)
+			return sb.String()
+		}
+		end := loc[0] + trimUnbalancedParen(line[loc[0]:loc[1]])
+		sb.WriteString(line[:loc[0]])
+		sb.WriteString(rewrite(line[loc[0]:end]))
+		line = line[end:]
+	}
+}
+
+// 匹配html中release资源的站内相对链接, 如 /user/repo/releases/download/tag/asset
+var relativeHrefPattern = regexp.MustCompile(`href="(/[^/"]+/[^/"]+/(?:releases/download|archive)/[^"]*)"`)
+
+// processLinks 使用的bufio对象池, 降低高并发下的内存分配
+var (
+	bufReaderPool = sync.Pool{
+		New: func() interface{} {
+			return bufio.NewReaderSize(nil, 4096)
+		},
+	}
+	bufWriterPool = sync.Pool{
+		New: func() interface{} {
+			return bufio.NewWriterSize(nil, 4096)
+		},
+	}
+)
+
+// 归还前解除对底层reader/writer的引用
+func putBufReader(r *bufio.Reader) {
+	r.Reset(nil)
+	bufReaderPool.Put(r)
+}
+
+func putBufWriter(w *bufio.Writer) {
+	w.Reset(nil)
+	bufWriterPool.Put(w)
+}
 
 // processLinks 处理链接，返回包含处理后数据的 io.Reader
-func processLinks(input io.ReadCloser, compress string, host string, cfg *config.Config) (readerOut io.Reader, written int64, err error) {
+// html 为 true 时, 先将 github.com 站内的资源相对链接补全为绝对链接再进行改写
+func processLinks(input io.ReadCloser, compress string, outCompress string, host string, cfg *config.Config, html bool, banner string, progress ProgressFunc) (readerOut io.Reader, written int64, err error) {
 	pipeReader, pipeWriter := io.Pipe() // 创建 io.Pipe
 	readerOut = pipeReader
 
 	go func() { // 在 Goroutine 中执行写入操作
+		// 使用goroutine内的变量, 避免与外层返回值竞争: 外层返回时会将err置为nil, 导致错误丢失、管道被正常关闭
+		var (
+			written int64
+			err     error
+		)
 		defer func() {
 			if pipeWriter != nil { // 确保 pipeWriter 关闭，即使发生错误
 				if err != nil {
@@ -242,7 +1134,9 @@ func processLinks(input io.ReadCloser, compress string, host string, cfg *config
 
 		}()
 
-		var bufReader *bufio.Reader
+		// bufio对象来自池, 仅在本goroutine内使用, 退出时归还
+		bufReader := bufReaderPool.Get().(*bufio.Reader)
+		defer putBufReader(bufReader)
 
 		if compress == "gzip" {
 			// 解压gzip
@@ -252,28 +1146,35 @@ func processLinks(input io.ReadCloser, compress string, host string, cfg *config
 				return // Goroutine 中使用 return 返回错误
 			}
 			defer gzipReader.Close()
-			bufReader = bufio.NewReader(gzipReader)
+			// gzip.Reader 默认读取全部拼接的member, 改写后输出时重新编码为单个member
+			bufReader.Reset(gzipReader)
 		} else {
-			bufReader = bufio.NewReader(input)
+			bufReader.Reset(input)
 		}
 
-		var bufWriter *bufio.Writer
 		var gzipWriter *gzip.Writer
+		bufWriter := bufWriterPool.Get().(*bufio.Writer)
+		defer putBufWriter(bufWriter)
 
-		// 根据是否gzip确定 writer 的创建
-		if compress == "gzip" {
-			gzipWriter = gzip.NewWriter(pipeWriter)           // 使用 pipeWriter
-			bufWriter = bufio.NewWriterSize(gzipWriter, 4096) //设置缓冲区大小
+		// 根据输出编码确定 writer 的目标
+		if outCompress == "gzip" {
+			gzipWriter = gzip.NewWriter(pipeWriter) // 使用 pipeWriter
+			bufWriter.Reset(gzipWriter)
 		} else {
-			bufWriter = bufio.NewWriterSize(pipeWriter, 4096) // 使用 pipeWriter
+			bufWriter.Reset(pipeWriter) // 使用 pipeWriter
 		}
 
-		//确保writer关闭
+		//确保writer关闭, 先flush缓冲再关闭gzip
 		defer func() {
-			var closeErr error // 局部变量，用于保存defer中可能发生的错误
-
+			if flushErr := bufWriter.Flush(); flushErr != nil {
+				logError("writer flush failed %v", flushErr)
+				// 如果已经存在错误，则保留。否则，记录此错误。
+				if err == nil {
+					err = flushErr
+				}
+			}
 			if gzipWriter != nil {
-				if closeErr = gzipWriter.Close(); closeErr != nil {
+				if closeErr := gzipWriter.Close(); closeErr != nil {
 					logError("gzipWriter close failed %v", closeErr)
 					// 如果已经存在错误，则保留。否则，记录此错误。
 					if err == nil {
@@ -281,18 +1182,35 @@ func processLinks(input io.ReadCloser, compress string, host string, cfg *config
 					}
 				}
 			}
-			if flushErr := bufWriter.Flush(); flushErr != nil {
-				logError("writer flush failed %v", flushErr)
-				// 如果已经存在错误，则保留。否则，记录此错误。
-				if err == nil {
-					err = flushErr
-				}
+		}()
+
+		lineReader := newLimitedLineReader(bufReader, cfg.Limits.MaxLineBytes)
+
+		if progress == nil {
+			progress = noopProgress
+		}
+		// 每输出 progressInterval 字节回调一次, 结束时以总量回调
+		var nextProgress int64 = progressInterval
+		defer func() {
+			if err == nil {
+				progress(written)
 			}
 		}()
 
+		// 横幅插入在首行之前, 首行为shebang时插入在其后
+		bannerPending := banner != ""
+		if bannerPending && !strings.HasSuffix(banner, "\n") {
+			banner += "\n"
+		}
+		firstLine := true
+
+		// 单个响应的改写次数, 超过 maxRewritesPerResponse 后其余链接原样输出
+		var rewrites int
+		maxRewrites := cfg.Limits.MaxRewritesPerResponse
+
 		// 使用正则表达式匹配 http 和 https 链接
 		for {
-			line, readErr := bufReader.ReadString('\n')
+			line, readErr := lineReader.ReadLine()
 			if readErr != nil {
 				if readErr == io.EOF {
 					break // 文件结束
@@ -301,18 +1219,78 @@ func processLinks(input io.ReadCloser, compress string, host string, cfg *config
 				return                                 // Goroutine 中使用 return 返回错误
 			}
 
+			if html {
+				line = relativeHrefPattern.ReplaceAllString(line, `href="https://github.com$1"`)
+			}
+
 			// 替换所有匹配的 URL
-			modifiedLine := urlPattern.ReplaceAllStringFunc(line, func(originalURL string) string {
+			modifiedLine := rewriteURLs(line, func(originalURL string) string {
 				logDump("originalURL: %s", originalURL)
-				return modifyURL(originalURL, host, cfg) // 假设 modifyURL 函数已定义
+				if maxRewrites > 0 && rewrites >= maxRewrites {
+					return originalURL
+				}
+				modifiedURL := modifyURL(originalURL, host, cfg) // 假设 modifyURL 函数已定义
+				if modifiedURL != originalURL {
+					GlobalStats.AddRewrites(1)
+					rewrites++
+					if rewrites == maxRewrites {
+						logWarning("Rewrite limit reached: %d, remaining URLs are passed through unchanged", maxRewrites)
+					}
+				}
+				return modifiedURL
 			})
 
+			if bannerPending {
+				if firstLine && !strings.HasPrefix(line, "#!") {
+					modifiedLine = banner + modifiedLine
+					bannerPending = false
+				} else if strings.HasSuffix(modifiedLine, "\n") {
+					// shebang行可能因 maxLineBytes 被分段, 在其完整结束后插入
+					modifiedLine += banner
+					bannerPending = false
+				}
+			}
+			firstLine = false
+
 			n, writeErr := bufWriter.WriteString(modifiedLine)
 			written += int64(n) // 更新写入的字节数
+			GlobalStats.AddBytes(int64(n))
 			if writeErr != nil {
 				err = fmt.Errorf("写入文件错误: %v", writeErr) // 传递错误
 				return                                   // Goroutine 中使用 return 返回错误
 			}
+			if written >= nextProgress {
+				progress(written)
+				nextProgress = written + progressInterval
+			}
+
+			// 逐行刷新, 使SSE等流式文本及时送达客户端
+			if cfg.Shell.FlushPerLine {
+				if flushErr := bufWriter.Flush(); flushErr != nil {
+					err = fmt.Errorf("刷新缓冲错误: %v", flushErr)
+					return
+				}
+				if gzipWriter != nil {
+					if flushErr := gzipWriter.Flush(); flushErr != nil {
+						err = fmt.Errorf("刷新gzip缓冲错误: %v", flushErr)
+						return
+					}
+				}
+			}
+		}
+
+		// 仅有一行不以换行结尾的shebang, 或响应体为空
+		if bannerPending {
+			if !firstLine {
+				banner = "\n" + banner
+			}
+			n, writeErr := bufWriter.WriteString(banner)
+			written += int64(n)
+			GlobalStats.AddBytes(int64(n))
+			if writeErr != nil {
+				err = fmt.Errorf("写入文件错误: %v", writeErr)
+				return
+			}
 		}
 
 		// 在返回之前，再刷新一次 (虽然 defer 中已经有 flush，但这里再加一次确保及时刷新)
@@ -324,5 +1302,7 @@ func processLinks(input io.ReadCloser, compress string, host string, cfg *config
 		}
 	}()
 
-	return readerOut, written, nil // 返回 reader 和 written，error 由 Goroutine 通过 pipeWriter.CloseWithError 传递
+	// 写入在goroutine中异步进行, 返回时 written 恒为0, 写入总量通过 progress 回调获得
+	// error 由 Goroutine 通过 pipeWriter.CloseWithError 传递
+	return readerOut, 0, nil
 }
@@ -0,0 +1,1073 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestModifyURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(cfg *config.Config)
+		in    string
+		want  string
+	}{
+		{
+			name: "default keeps scheme",
+			in:   "https://github.com/user/repo/raw/main/install.sh",
+			want: "https://proxy.example/https://github.com/user/repo/raw/main/install.sh",
+		},
+		{
+			name:  "omitSchemeInRewrite",
+			setup: func(cfg *config.Config) { cfg.Shell.OmitSchemeInRewrite = true },
+			in:    "https://github.com/user/repo/raw/main/install.sh",
+			want:  "https://proxy.example/github.com/user/repo/raw/main/install.sh",
+		},
+		{
+			name: "non github link unchanged",
+			in:   "https://example.com/install.sh",
+			want: "https://example.com/install.sh",
+		},
+		{
+			name: "signed object url unchanged",
+			in:   "https://objects.githubusercontent.com/github-production-release-asset-2e65be/1/2?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc",
+			want: "https://objects.githubusercontent.com/github-production-release-asset-2e65be/1/2?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc",
+		},
+		{
+			name: "codeload archive",
+			in:   "https://codeload.github.com/user/repo/tar.gz/refs/tags/v1.0",
+			want: "https://proxy.example/https://codeload.github.com/user/repo/tar.gz/refs/tags/v1.0",
+		},
+		{
+			name: "legacy raw.github.com",
+			in:   "https://raw.github.com/user/repo/main/install.sh",
+			want: "https://proxy.example/https://raw.githubusercontent.com/user/repo/main/install.sh",
+		},
+		{
+			name: "git submodule url",
+			in:   "git://github.com/user/repo.git",
+			want: "https://proxy.example/https://github.com/user/repo.git",
+		},
+		{
+			name: "git url of non github host unchanged",
+			in:   "git://example.com/user/repo.git",
+			want: "git://example.com/user/repo.git",
+		},
+		{
+			name: "uppercase scheme and host",
+			in:   "HTTPS://GITHUB.COM/user/repo/raw/main/install.sh",
+			want: "https://proxy.example/https://github.com/user/repo/raw/main/install.sh",
+		},
+		{
+			name: "uppercase scheme of non github link unchanged",
+			in:   "HTTPS://EXAMPLE.COM/Install.sh",
+			want: "HTTPS://EXAMPLE.COM/Install.sh",
+		},
+		{
+			name:  "strip tracking params keeps token",
+			setup: func(cfg *config.Config) { cfg.Shell.StripQueryParams = []string{"utm_*", "ref", "token"} },
+			in:    "https://github.com/user/repo/raw/main/install.sh?utm_source=readme&token=abc&ref=home&v=2",
+			want:  "https://proxy.example/https://github.com/user/repo/raw/main/install.sh?token=abc&v=2",
+		},
+		{
+			name:  "strip all params keeps fragment",
+			setup: func(cfg *config.Config) { cfg.Shell.StripQueryParams = []string{"utm_*"} },
+			in:    "https://github.com/user/repo/blob/main/README.md?utm_source=x&utm_medium=y#usage",
+			want:  "https://proxy.example/https://github.com/user/repo/blob/main/README.md#usage",
+		},
+		{
+			name:  "path prefix",
+			setup: func(cfg *config.Config) { cfg.Server.PathPrefix = "/ghproxy/" },
+			in:    "https://github.com/user/repo/raw/main/install.sh",
+			want:  "https://proxy.example/ghproxy/https://github.com/user/repo/raw/main/install.sh",
+		},
+		{
+			name:  "untrusted rewrite host",
+			setup: func(cfg *config.Config) { cfg.Server.TrustedHosts = []string{"mirror.example"} },
+			in:    "https://github.com/user/repo/raw/main/install.sh",
+			want:  "https://mirror.example/https://github.com/user/repo/raw/main/install.sh",
+		},
+		{
+			name:  "already proxied link unchanged",
+			setup: func(cfg *config.Config) { cfg.Shell.PreventDoubleProxy = true },
+			in:    "https://proxy.example/https://github.com/user/repo/raw/main/install.sh",
+			want:  "https://proxy.example/https://github.com/user/repo/raw/main/install.sh",
+		},
+		{
+			name: "host alias",
+			setup: func(cfg *config.Config) {
+				cfg.Shell.HostAliases = map[string]string{"User-Images.githubusercontent.com": "private-user-images.githubusercontent.com"}
+			},
+			in:   "https://user-images.githubusercontent.com/1/a.png?v=1",
+			want: "https://proxy.example/https://private-user-images.githubusercontent.com/1/a.png?v=1",
+		},
+		{
+			name: "host alias matches whole host only",
+			setup: func(cfg *config.Config) {
+				cfg.Shell.HostAliases = map[string]string{"github.com": "www.github.com"}
+			},
+			in:   "https://gist.github.com/user/abc123",
+			want: "https://proxy.example/https://gist.github.com/user/abc123",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			if got := modifyURL(tt.in, "proxy.example", cfg); got != tt.want {
+				t.Errorf("modifyURL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkEditorMatch(t *testing.T) {
+	urls := []string{
+		"https://github.com/user/repo/raw/main/install.sh",
+		"https://raw.githubusercontent.com/user/repo/main/install.sh",
+		"https://gist.githubusercontent.com/user/abc123/raw/a.sh",
+		"https://api.github.com/repos/user/repo/releases/latest",
+		"https://example.com/install.sh",
+		"https://example.com/install.sh",
+	}
+	tests := []struct {
+		name  string
+		setup func(cfg *config.Config)
+		want  map[string]bool
+	}{
+		{
+			name: "defaults",
+			want: map[string]bool{
+				"https://github.com/user/repo/raw/main/install.sh":            true,
+				"https://raw.githubusercontent.com/user/repo/main/install.sh": true,
+				"https://gist.githubusercontent.com/user/abc123/raw/a.sh":     true,
+				"https://api.github.com/repos/user/repo/releases/latest":      false,
+				"https://example.com/install.sh":                              false,
+			},
+		},
+		{
+			name:  "rewriteAPI",
+			setup: func(cfg *config.Config) { cfg.Shell.RewriteAPI = true },
+			want: map[string]bool{
+				"https://github.com/user/repo/raw/main/install.sh":            true,
+				"https://raw.githubusercontent.com/user/repo/main/install.sh": true,
+				"https://gist.githubusercontent.com/user/abc123/raw/a.sh":     true,
+				"https://api.github.com/repos/user/repo/releases/latest":      true,
+				"https://example.com/install.sh":                              false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			got := BulkEditorMatch(urls, cfg)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d decisions, want %d: %v", len(got), len(tt.want), got)
+			}
+			for u, want := range tt.want {
+				if got[u] != want {
+					t.Errorf("BulkEditorMatch[%q] = %v, want %v", u, got[u], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeRewriteHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		canonical string
+		trusted   []string
+		want      string
+		wantOK    bool
+	}{
+		{name: "no restrictions", host: "proxy.example", want: "proxy.example", wantOK: true},
+		{name: "with port", host: "proxy.example:8080", want: "proxy.example:8080", wantOK: true},
+		{name: "canonical host overrides", host: "evil.example", canonical: "proxy.example", want: "proxy.example", wantOK: true},
+		{name: "trusted host", host: "Mirror.Example", trusted: []string{"proxy.example", "mirror.example"}, want: "Mirror.Example", wantOK: true},
+		{name: "untrusted falls back to first trusted", host: "evil.example", trusted: []string{"proxy.example"}, want: "proxy.example", wantOK: true},
+		{name: "untrusted falls back to canonical", host: "evil.example", canonical: "cdn.example", trusted: []string{"proxy.example"}, want: "cdn.example", wantOK: true},
+		{name: "path injection", host: "evil.example/https:", trusted: []string{"proxy.example"}, want: "proxy.example", wantOK: true},
+		{name: "userinfo injection", host: "proxy.example@evil.example", want: "", wantOK: false},
+		{name: "empty host", host: "", want: "", wantOK: false},
+		{name: "empty host with canonical", host: "", canonical: "proxy.example", want: "proxy.example", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.CanonicalHost = tt.canonical
+			cfg.Server.TrustedHosts = tt.trusted
+			got, ok := sanitizeRewriteHost(tt.host, cfg)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("sanitizeRewriteHost(%q) = %q, %v; want %q, %v", tt.host, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUnwrapProxyURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		trusted   []string
+		prefix    string
+		want      string
+		unwrapped bool
+	}{
+		{name: "plain", in: "https://github.com/user/repo/raw/main/a.sh", want: "https://github.com/user/repo/raw/main/a.sh"},
+		{name: "one level", in: "https://proxy.example/https://github.com/user/repo/raw/main/a.sh", want: "https://github.com/user/repo/raw/main/a.sh", unwrapped: true},
+		{name: "nested", in: "https://proxy.example/http://PROXY.example/https://github.com/user/repo/raw/main/a.sh", want: "https://github.com/user/repo/raw/main/a.sh", unwrapped: true},
+		{name: "trusted host", in: "https://mirror.example/https://github.com/user/repo/raw/main/a.sh", trusted: []string{"mirror.example"}, want: "https://github.com/user/repo/raw/main/a.sh", unwrapped: true},
+		{name: "path prefix", in: "https://proxy.example/ghproxy/https://github.com/user/repo/raw/main/a.sh", prefix: "/ghproxy", want: "https://github.com/user/repo/raw/main/a.sh", unwrapped: true},
+		{name: "other host", in: "https://other.example/https://github.com/user/repo/raw/main/a.sh", want: "https://other.example/https://github.com/user/repo/raw/main/a.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.TrustedHosts = tt.trusted
+			cfg.Server.PathPrefix = tt.prefix
+			got, unwrapped := unwrapProxyURL(tt.in, "proxy.example", cfg)
+			if got != tt.want || unwrapped != tt.unwrapped {
+				t.Errorf("unwrapProxyURL(%q) = %q, %v; want %q, %v", tt.in, got, unwrapped, tt.want, tt.unwrapped)
+			}
+		})
+	}
+}
+
+func TestPopRawOverride(t *testing.T) {
+	tests := []struct {
+		in           string
+		want         string
+		wantOverride bool
+	}{
+		{in: "https://github.com/user/repo/raw/main/a.sh", want: "https://github.com/user/repo/raw/main/a.sh"},
+		{in: "https://github.com/user/repo/raw/main/a.sh?ghproxy_raw=1", want: "https://github.com/user/repo/raw/main/a.sh", wantOverride: true},
+		{in: "https://github.com/user/repo/raw/main/a.sh?token=x&ghproxy_raw=true", want: "https://github.com/user/repo/raw/main/a.sh?token=x", wantOverride: true},
+		{in: "https://github.com/user/repo/raw/main/a.sh?ghproxy_raw=0&v=2", want: "https://github.com/user/repo/raw/main/a.sh?v=2"},
+		{in: "https://github.com/user/repo/raw/main/a.sh?ghproxy_rawx=1", want: "https://github.com/user/repo/raw/main/a.sh?ghproxy_rawx=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, override := popRawOverride(tt.in)
+			if got != tt.want || override != tt.wantOverride {
+				t.Errorf("popRawOverride(%q) = %q, %v; want %q, %v", tt.in, got, override, tt.want, tt.wantOverride)
+			}
+		})
+	}
+}
+
+func TestMatchRawPathGist(t *testing.T) {
+	tests := []struct {
+		url        string
+		wantStatus int // 0 为匹配成功
+		wantUser   string
+		wantGistID string
+	}{
+		{url: "https://gist.github.com/user/abc123.js", wantUser: "user", wantGistID: "abc123"},
+		{url: "https://gist.github.com/user/abc123.js?file=a.sh", wantUser: "user", wantGistID: "abc123"},
+		{url: "https://gist.github.com/user/abc123/raw/def/a.sh", wantUser: "user", wantGistID: "abc123"},
+		{url: "https://gist.github.com/user/abc123", wantStatus: 400},
+		{url: "https://gist.github.com/user/.js", wantStatus: 400},
+		{url: "https://gist.github.com/user", wantStatus: 400},
+		{url: "https://gist.githubusercontent.com/user/abc123/raw/a.sh", wantUser: "user", wantGistID: "abc123"},
+	}
+	cfg := config.DefaultConfig()
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			result, errInfo := matchRawPath(tt.url, cfg)
+			if tt.wantStatus != 0 {
+				if errInfo == nil || errInfo.StatusCode != tt.wantStatus {
+					t.Fatalf("matchRawPath(%q) = %+v, %v; want status %d", tt.url, result, errInfo, tt.wantStatus)
+				}
+				return
+			}
+			if errInfo != nil {
+				t.Fatalf("matchRawPath(%q): %s", tt.url, errInfo.ErrorMessage)
+			}
+			if result.Matcher != MatcherGist || result.User != tt.wantUser || result.GistID != tt.wantGistID {
+				t.Errorf("matchRawPath(%q) = %s %s/%s, want gist %s/%s", tt.url, result.Matcher, result.User, result.GistID, tt.wantUser, tt.wantGistID)
+			}
+		})
+	}
+}
+
+func TestContentTypeOverride(t *testing.T) {
+	overrides := map[string]string{
+		".sh":     "application/x-sh",
+		"sh":      "text/x-sh",
+		".tar.sh": "application/x-tar-sh",
+		"ps1":     "text/plain",
+	}
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{path: "https://github.com/user/repo/raw/main/install.sh", want: "application/x-sh", wantOK: true},
+		{path: "https://github.com/user/repo/raw/main/install.sh?token=abc", want: "application/x-sh", wantOK: true},
+		{path: "https://github.com/user/repo/raw/main/install.sh#L10", want: "application/x-sh", wantOK: true},
+		{path: "https://github.com/user/repo/raw/main/bundle.tar.sh", want: "application/x-tar-sh", wantOK: true},
+		{path: "https://github.com/user/repo/raw/main/setup.ps1", want: "text/plain", wantOK: true},
+		{path: "https://github.com/user/repo/raw/main/notes.txt"},
+		{path: "https://github.com/user/repo/raw/main/notes.txt?x=.sh"},
+	}
+	cfg := config.DefaultConfig()
+	cfg.Shell.ContentTypeOverrides = overrides
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// map遍历顺序随机, 多次调用结果应一致
+			for i := 0; i < 20; i++ {
+				got, ok := contentTypeOverride(tt.path, cfg)
+				if got != tt.want || ok != tt.wantOK {
+					t.Fatalf("contentTypeOverride(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheControlFor(t *testing.T) {
+	const sha = "3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15"
+	tests := []struct {
+		name     string
+		ref      string
+		refValue string
+		want     string
+	}{
+		{name: "sha", ref: sha, want: "public, max-age=31536000, immutable"},
+		{name: "sha256", ref: strings.Repeat("ab", 32), want: "public, max-age=31536000, immutable"},
+		{name: "uppercase sha", ref: strings.ToUpper(sha), want: "public, max-age=31536000, immutable"},
+		{name: "short sha", ref: sha[:7], want: "no-cache"},
+		{name: "branch", ref: "main", want: "no-cache"},
+		{name: "40 chars non hex", ref: strings.Repeat("g", 40), want: "no-cache"},
+		{name: "empty ref", want: "no-cache"},
+		{name: "branch with configured value", ref: "main", refValue: "public, max-age=60", want: "public, max-age=60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Shell.CacheControl.ShaMaxAge = 31536000
+			cfg.Shell.CacheControl.RefValue = tt.refValue
+			if got := cacheControlFor(tt.ref, cfg); got != tt.want {
+				t.Errorf("cacheControlFor(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+// gzipMembers 将每个部分分别压缩为独立的gzip member后拼接
+func gzipMembers(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, part := range parts {
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestProcessLinksGzipMembers(t *testing.T) {
+	const want = "echo https://proxy.example/https://github.com/user/repo/raw/main/a.sh\n" +
+		"curl https://proxy.example/https://raw.githubusercontent.com/user/repo/main/b.sh\n"
+	tests := []struct {
+		name  string
+		parts []string
+	}{
+		{name: "single member", parts: []string{
+			"echo https://github.com/user/repo/raw/main/a.sh\ncurl https://raw.githubusercontent.com/user/repo/main/b.sh\n",
+		}},
+		{name: "split at line", parts: []string{
+			"echo https://github.com/user/repo/raw/main/a.sh\n",
+			"curl https://raw.githubusercontent.com/user/repo/main/b.sh\n",
+		}},
+		{name: "split inside url", parts: []string{
+			"echo https://github.com/user/repo/raw/main/a.sh\ncurl https://raw.githubuser",
+			"content.com/user/repo/main/b.sh\n",
+		}},
+		{name: "empty member", parts: []string{
+			"echo https://github.com/user/repo/raw/main/a.sh\n",
+			"",
+			"curl https://raw.githubusercontent.com/user/repo/main/b.sh\n",
+		}},
+	}
+	for _, tt := range tests {
+		for _, outCompress := range []string{"", "gzip"} {
+			t.Run(tt.name+"/out="+outCompress, func(t *testing.T) {
+				input := io.NopCloser(bytes.NewReader(gzipMembers(t, tt.parts...)))
+				reader, _, err := processLinks(input, "gzip", outCompress, "proxy.example", config.DefaultConfig(), false, "", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("read output: %v", err)
+				}
+				if outCompress == "gzip" {
+					gz, err := gzip.NewReader(bytes.NewReader(out))
+					if err != nil {
+						t.Fatalf("invalid gzip output: %v", err)
+					}
+					// 输出应为单个member
+					gz.Multistream(false)
+					if out, err = io.ReadAll(gz); err != nil {
+						t.Fatalf("read gzip output: %v", err)
+					}
+				}
+				if string(out) != want {
+					t.Errorf("output = %q, want %q", out, want)
+				}
+			})
+		}
+	}
+}
+
+// 并发改写时池化的bufio对象不能在goroutine之间串用
+func TestProcessLinksPooledConcurrent(t *testing.T) {
+	const workers = 32
+	cfg := config.DefaultConfig()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var in, want strings.Builder
+			for j := 0; j < 200; j++ {
+				fmt.Fprintf(&in, "curl https://github.com/user%d/repo/raw/main/%d.sh\n", i, j)
+				fmt.Fprintf(&want, "curl https://proxy.example/https://github.com/user%d/repo/raw/main/%d.sh\n", i, j)
+			}
+			compress := ""
+			if i%2 == 1 {
+				compress = "gzip"
+			}
+			reader, _, err := processLinks(io.NopCloser(strings.NewReader(in.String())), "", compress, "proxy.example", cfg, false, "", nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			out, err := io.ReadAll(reader)
+			if err == nil && compress == "gzip" {
+				var gz *gzip.Reader
+				if gz, err = gzip.NewReader(bytes.NewReader(out)); err == nil {
+					out, err = io.ReadAll(gz)
+				}
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(out) != want.String() {
+				errs <- fmt.Errorf("worker %d: output mismatch", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkProcessLinks(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, "curl -fsSL https://github.com/user/repo/raw/main/%d.sh | bash\n", i)
+	}
+	script := sb.String()
+	cfg := config.DefaultConfig()
+	for _, outCompress := range []string{"", "gzip"} {
+		b.Run("out="+outCompress, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(script)))
+			for i := 0; i < b.N; i++ {
+				reader, _, err := processLinks(io.NopCloser(strings.NewReader(script)), "", outCompress, "proxy.example", cfg, false, "", nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchRawPath(t *testing.T) {
+	tests := []struct {
+		url        string
+		setup      func(cfg *config.Config)
+		want       MatchResult // URL 为空时不比较
+		wantStatus int         // 非0时期望匹配失败, want 中的 User/Repo 为出错时保留的部分结果
+		wantMsg    string      // 非空时错误信息需包含该内容
+	}{
+		// raw 的 ref 提取
+		{url: "https://github.com/user/repo/raw/main/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://github.com/user/repo/raw/refs/heads/dev/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "refs/heads/dev"}},
+		{url: "https://github.com/user/repo/raw/refs/tags/v1.0/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "refs/tags/v1.0"}},
+		{url: "https://raw.githubusercontent.com/user/repo/main/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://raw.githubusercontent.com/user/repo/refs/heads/dev/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "refs/heads/dev",
+			URL: "https://raw.githubusercontent.com/user/repo/refs/heads/dev/a.sh"}},
+		{url: "https://raw.githubusercontent.com/user/repo/main/a.sh?token=x", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://raw.githubusercontent.com/user/repo/HEAD/a.sh", setup: func(cfg *config.Config) { cfg.Upstream.DefaultBranch = "main" },
+			want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main", URL: "https://raw.githubusercontent.com/user/repo/main/a.sh"}},
+		{url: "https://raw.githubusercontent.com/user/repo", wantStatus: 400},
+		// jsDelivr 风格的 /gh/ 路径, 需开启 shell.enableCDNPaths
+		{url: "https://gh/user/repo@main/dist/a.js", setup: func(cfg *config.Config) { cfg.Shell.EnableCDNPaths = true },
+			want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main", URL: "https://raw.githubusercontent.com/user/repo/main/dist/a.js"}},
+		{url: "https://gh/user/repo/a.js", setup: func(cfg *config.Config) { cfg.Shell.EnableCDNPaths = true },
+			want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", URL: "https://raw.githubusercontent.com/user/repo/HEAD/a.js"}},
+		{url: "https://gh/user/repo@/a.js", setup: func(cfg *config.Config) { cfg.Shell.EnableCDNPaths = true }, wantStatus: 400},
+		{url: "https://gh/user/repo@main", setup: func(cfg *config.Config) { cfg.Shell.EnableCDNPaths = true }, wantStatus: 400},
+		{url: "https://gh/user/repo@main/a.js", wantStatus: 404},
+		// smart/dumb HTTP 协议的clone路径
+		{url: "https://github.com/user/repo/info/refs?service=git-upload-pack", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo.git/git-upload-pack", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/objects/info/packs", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/HEAD", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		// Git LFS batch api 与对象存储
+		{url: "https://github.com/user/repo.git/info/lfs/objects/batch", want: MatchResult{Matcher: MatcherLFS, User: "user", Repo: "repo"}},
+		{url: "https://github-cloud.githubusercontent.com/alambic/media/1/abc", want: MatchResult{Matcher: MatcherLFS}},
+		{url: "https://media.githubusercontent.com/media/user/repo/main/big.bin", want: MatchResult{Matcher: MatcherLFS}},
+		// GitHub Packages, 需开启 upstream.allowPackages
+		{url: "https://npm.pkg.github.com/@user/pkg", setup: func(cfg *config.Config) { cfg.Upstream.AllowPackages = true },
+			want: MatchResult{Matcher: MatcherPackages, User: "user", Repo: "pkg"}},
+		{url: "https://npm.pkg.github.com/@user%2fpkg", setup: func(cfg *config.Config) { cfg.Upstream.AllowPackages = true },
+			want: MatchResult{Matcher: MatcherPackages, User: "user", Repo: "pkg"}},
+		{url: "https://maven.pkg.github.com/user/repo/com/example/lib/1.0/lib-1.0.jar", setup: func(cfg *config.Config) { cfg.Upstream.AllowPackages = true },
+			want: MatchResult{Matcher: MatcherPackages, User: "user", Repo: "repo"}},
+		{url: "https://npm.pkg.github.com/", setup: func(cfg *config.Config) { cfg.Upstream.AllowPackages = true }, wantStatus: 400},
+		{url: "https://npm.pkg.github.com/@user/pkg", wantStatus: 404},
+		{url: "https://maven.pkg.github.com/user/repo/com/example/lib/1.0/lib-1.0.jar", wantStatus: 404},
+		// 旧版 github.com/downloads 下载链接
+		{url: "https://github.com/downloads/user/repo/file.zip", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo",
+			URL: "https://github.com/downloads/user/repo/file.zip"}},
+		{url: "https://github.com/downloads/user", wantStatus: 400},
+		// release 页面懒加载的资源列表
+		{url: "https://github.com/user/repo/releases/expanded_assets/v1.0", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo"}},
+		// blob 带 ?raw=true 时按raw处理
+		{url: "https://github.com/user/repo/blob/main/a.sh?raw=true", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main",
+			URL: "https://github.com/user/repo/raw/main/a.sh?raw=true"}},
+		{url: "https://github.com/user/repo/blob/main/a.sh?plain=1&raw=true", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main",
+			URL: "https://github.com/user/repo/raw/main/a.sh?plain=1&raw=true"}},
+		{url: "https://github.com/user/repo/blob/main/a.sh?raw=1", want: MatchResult{Matcher: MatcherBlob, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://github.com/user/repo/blob/main/a.sh", want: MatchResult{Matcher: MatcherBlob, User: "user", Repo: "repo", Ref: "main"}},
+		// release 资源的签名对象存储链接, url原样保留
+		{url: "https://objects.githubusercontent.com/github-production-release-asset-2e65be/1/2?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIA%2F20240101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Signature=abc&response-content-disposition=attachment%3B%20filename%3Dapp.zip",
+			want: MatchResult{Matcher: MatcherObject, URL: "https://objects.githubusercontent.com/github-production-release-asset-2e65be/1/2?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIA%2F20240101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Signature=abc&response-content-disposition=attachment%3B%20filename%3Dapp.zip"}},
+		// raw 的 @ref 语法
+		{url: "https://raw.githubusercontent.com/user/repo@v1.2.3/dir/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "v1.2.3",
+			URL: "https://raw.githubusercontent.com/user/repo/v1.2.3/dir/a.sh"}},
+		{url: "https://raw.githubusercontent.com/user/repo@main/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main",
+			URL: "https://raw.githubusercontent.com/user/repo/main/a.sh"}},
+		{url: "https://raw.githubusercontent.com/user/@v1/a.sh", wantStatus: 400},
+		{url: "https://raw.githubusercontent.com/user/repo@/a.sh", wantStatus: 400},
+		// tree 目录页
+		{url: "https://github.com/user/repo/tree/main/dir/sub", want: MatchResult{Matcher: MatcherTree, User: "user", Repo: "repo", Ref: "main", Path: "dir/sub"}},
+		{url: "https://github.com/user/repo/tree/refs/heads/feature/dir", want: MatchResult{Matcher: MatcherTree, User: "user", Repo: "repo", Ref: "refs/heads/feature", Path: "dir"}},
+		{url: "https://github.com/user/repo/tree/main", want: MatchResult{Matcher: MatcherTree, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://github.com/user/repo/tree", wantStatus: 400},
+		// raw.githubusercontent.com/gist/ 单文件
+		{url: "https://raw.githubusercontent.com/gist/user/abc123/raw/a.sh", want: MatchResult{Matcher: MatcherGist, User: "user", GistID: "abc123"}},
+		{url: "https://raw.githubusercontent.com/gist/user/abc123/def456/a.sh", want: MatchResult{Matcher: MatcherGist, User: "user", GistID: "abc123"}},
+		{url: "https://raw.githubusercontent.com/gist/user", wantStatus: 400},
+		{url: "https://raw.githubusercontent.com/gist/user/", wantStatus: 400},
+		// repo.git 形式, repo 去除 .git 后缀, 上游url保持不变
+		{url: "https://github.com/user/repo.git", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo", URL: "https://github.com/user/repo.git"}},
+		{url: "https://github.com/user/repo.git/info/refs?service=git-upload-pack", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo",
+			URL: "https://github.com/user/repo.git/info/refs?service=git-upload-pack"}},
+		{url: "https://github.com/user/.git", wantStatus: 400},
+		// 文件查找器, 需开启 upstream.allowFileFinder
+		{url: "https://github.com/user/repo/find/main", setup: func(cfg *config.Config) { cfg.Upstream.AllowFileFinder = true },
+			want: MatchResult{Matcher: MatcherFind, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://github.com/user/repo/find/main?q=readme", setup: func(cfg *config.Config) { cfg.Upstream.AllowFileFinder = true },
+			want: MatchResult{Matcher: MatcherFind, User: "user", Repo: "repo", Ref: "main"}},
+		{url: "https://github.com/user/repo/search?q=readme", setup: func(cfg *config.Config) { cfg.Upstream.AllowFileFinder = true },
+			want: MatchResult{Matcher: MatcherFind, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/find", setup: func(cfg *config.Config) { cfg.Upstream.AllowFileFinder = true }, wantStatus: 400},
+		{url: "https://github.com/user/repo/find/main", wantStatus: 400},
+		{url: "https://github.com/user/repo/security?tab=advisories", wantStatus: 403},
+		// blame 页面
+		{url: "https://github.com/user/repo/blame/main/cmd/a.go", want: MatchResult{Matcher: MatcherBlame, User: "user", Repo: "repo", Ref: "main", Path: "cmd/a.go"}},
+		{url: "https://github.com/user/repo/blame/refs/heads/dev/a.go", want: MatchResult{Matcher: MatcherBlame, User: "user", Repo: "repo", Ref: "refs/heads/dev", Path: "a.go"}},
+		{url: "https://github.com/user/repo/blame/main", wantStatus: 400},
+		{url: "https://github.com/user/repo/blame/refs/heads/dev", wantStatus: 400},
+		// LFS 文件锁api
+		{url: "https://github.com/user/repo.git/info/lfs/locks", want: MatchResult{Matcher: MatcherLFS, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo.git/info/lfs/locks/verify", want: MatchResult{Matcher: MatcherLFS, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo.git/info/lfs/locks/1/unlock", want: MatchResult{Matcher: MatcherLFS, User: "user", Repo: "repo"}},
+		// archive 归档链接, ref 与格式分别提取
+		{url: "https://github.com/user/repo/archive/refs/tags/v1.0.tar.gz", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo", Ref: "refs/tags/v1.0", Format: "tar.gz"}},
+		{url: "https://github.com/user/repo/archive/refs/tags/v1.0.zip", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo", Ref: "refs/tags/v1.0", Format: "zip"}},
+		{url: "https://github.com/user/repo/archive/refs/heads/main.tar.gz", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo", Ref: "refs/heads/main", Format: "tar.gz"}},
+		{url: "https://github.com/user/repo/archive/main.zip", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo", Ref: "main", Format: "zip"}},
+		{url: "https://github.com/user/repo/archive/v1.rar", wantStatus: 400},
+		{url: "https://codeload.github.com/user/repo/tar.gz/refs/heads/main", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo", Ref: "refs/heads/main", Format: "tar.gz"}},
+		{url: "https://codeload.github.com/user/repo/legacy.zip/refs/tags/v1.0", want: MatchResult{Matcher: MatcherReleases, User: "user", Repo: "repo", Ref: "refs/tags/v1.0", Format: "zip"}},
+		{url: "https://codeload.github.com/user/repo/rar/main", wantStatus: 400},
+		// 旧版 raw.github.com 规范化为 raw.githubusercontent.com
+		{url: "https://raw.github.com/user/repo/main/a.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main",
+			URL: "https://raw.githubusercontent.com/user/repo/main/a.sh"}},
+		// api.github.com
+		{url: "https://api.github.com/repos/user/repo/git/blobs/3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15", want: MatchResult{Matcher: MatcherAPI, User: "user", Repo: "repo"}},
+		{url: "https://api.github.com/repos/user/repo/git/trees/main?recursive=1", want: MatchResult{Matcher: MatcherAPI, User: "user", Repo: "repo",
+			URL: "https://api.github.com/repos/user/repo/git/trees/main?recursive=1"}},
+		{url: "https://api.github.com/users/user", want: MatchResult{Matcher: MatcherAPI, User: "user"}},
+		{url: "https://api.github.com/repos/user/repo?per_page=1", want: MatchResult{Matcher: MatcherAPI, User: "user", Repo: "repo"}},
+		{url: "https://api.github.com/repos/user", want: MatchResult{Matcher: MatcherAPI, User: "user"}},
+		{url: "https://api.github.com/repos", want: MatchResult{Matcher: MatcherAPI}},
+		{url: "https://api.github.com/users", want: MatchResult{Matcher: MatcherAPI}},
+		{url: "https://api.github.com/rate_limit", want: MatchResult{Matcher: MatcherAPI}},
+		// Sponsors 页面
+		{url: "https://github.com/sponsors/user", wantStatus: 403, wantMsg: "https://github.com/sponsors/user directly"},
+		{url: "https://github.com/sponsors/user/", wantStatus: 403, wantMsg: "Sponsors page of 'user'"},
+		{url: "https://github.com/sponsors", wantStatus: 403, wantMsg: "Sponsors pages are not proxied"},
+		// 控制字符在拆分前拒绝, 包括百分号编码形式
+		{url: "https://github.com/user/repo/raw/main/a.sh\r\nX-Injected: 1", wantStatus: 400, wantMsg: "control characters"},
+		{url: "https://github.com/user/repo/raw/main/a\n.sh", wantStatus: 400, wantMsg: "control characters"},
+		{url: "https://github.com/user/repo/raw/main/a\x00.sh", wantStatus: 400, wantMsg: "control characters"},
+		{url: "https://github.com/user/repo/raw/main/a%0d%0a.sh", wantStatus: 400, wantMsg: "control characters"},
+		{url: "https://github.com/user/repo/raw/main/a%00.sh", wantStatus: 400, wantMsg: "control characters"},
+		{url: "https://github.com/user/repo/raw/main/a%7f.sh", wantStatus: 400, wantMsg: "control characters"},
+		{url: "https://github.com/user/repo/raw/main/a%20b.sh", want: MatchResult{Matcher: MatcherRaw, User: "user", Repo: "repo", Ref: "main"}},
+		// 出错时保留已解析出的user/repo, 供日志与审计使用
+		{url: "https://github.com/user/repo/settings", wantStatus: 403, want: MatchResult{User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/pulls", wantStatus: 400, want: MatchResult{User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/releases/download/v1.0", wantStatus: 400, want: MatchResult{User: "user", Repo: "repo"}},
+		// dumb HTTP 协议的对象路径
+		{url: "https://github.com/user/repo.git/info/refs", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo.git/HEAD", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo.git/objects/3a/0f86fb8db8eea7ccbb9a95f325ddbedfb25e15", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/objects/pack/pack-3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15.pack", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+		{url: "https://github.com/user/repo/objects/info/alternates", want: MatchResult{Matcher: MatcherClone, User: "user", Repo: "repo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			result, errInfo := matchRawPath(tt.url, cfg)
+			if tt.wantStatus != 0 {
+				if errInfo == nil || errInfo.StatusCode != tt.wantStatus {
+					t.Fatalf("matchRawPath(%q) = %+v, %v; want status %d", tt.url, result, errInfo, tt.wantStatus)
+				}
+				if !strings.Contains(errInfo.ErrorMessage, tt.wantMsg) {
+					t.Errorf("matchRawPath(%q) message = %q, want containing %q", tt.url, errInfo.ErrorMessage, tt.wantMsg)
+				}
+				if tt.want.User != "" || tt.want.Repo != "" {
+					if result == nil || result.User != tt.want.User || result.Repo != tt.want.Repo {
+						t.Errorf("matchRawPath(%q) partial result = %+v, want user/repo %q/%q", tt.url, result, tt.want.User, tt.want.Repo)
+					}
+				}
+				return
+			}
+			if errInfo != nil {
+				t.Fatalf("matchRawPath(%q): %d %s", tt.url, errInfo.StatusCode, errInfo.ErrorMessage)
+			}
+			got := *result
+			got.Parsed = nil
+			if tt.want.URL == "" {
+				got.URL = ""
+			}
+			if got != tt.want {
+				t.Errorf("matchRawPath(%q)\n got  %+v\n want %+v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessLinksHTMLFragment(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		html bool
+		want string
+	}{
+		{
+			name: "relative release asset",
+			in:   `<a href="/user/repo/releases/download/v1.0/app.zip" rel="nofollow">app.zip</a>` + "\n",
+			html: true,
+			want: `<a href="https://proxy.example/https://github.com/user/repo/releases/download/v1.0/app.zip" rel="nofollow">app.zip</a>` + "\n",
+		},
+		{
+			name: "relative archive",
+			in:   `<a href="/user/repo/archive/refs/tags/v1.0.tar.gz">Source code</a>` + "\n",
+			html: true,
+			want: `<a href="https://proxy.example/https://github.com/user/repo/archive/refs/tags/v1.0.tar.gz">Source code</a>` + "\n",
+		},
+		{
+			name: "other relative link unchanged",
+			in:   `<a href="/user/repo/releases/tag/v1.0">v1.0</a>` + "\n",
+			html: true,
+			want: `<a href="/user/repo/releases/tag/v1.0">v1.0</a>` + "\n",
+		},
+		{
+			name: "relative link outside html mode",
+			in:   `<a href="/user/repo/releases/download/v1.0/app.zip">app.zip</a>` + "\n",
+			want: `<a href="/user/repo/releases/download/v1.0/app.zip">app.zip</a>` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, _, err := processLinks(io.NopCloser(strings.NewReader(tt.in)), "", "", "proxy.example", config.DefaultConfig(), tt.html, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(cfg *config.Config)
+		in     string
+		banner string
+		want   string
+	}{
+		{
+			name: "gitmodules git url",
+			in:   "[submodule \"lib\"]\n\tpath = lib\n\turl = git://github.com/user/lib.git\n",
+			want: "[submodule \"lib\"]\n\tpath = lib\n\turl = https://proxy.example/https://github.com/user/lib.git\n",
+		},
+		{
+			name: "uppercase scheme keeps path case",
+			in:   "curl -fsSL HTTPS://GitHub.com/User/Repo/raw/main/Install.sh | sh\n",
+			want: "curl -fsSL https://proxy.example/https://github.com/User/Repo/raw/main/Install.sh | sh\n",
+		},
+		{
+			name:  "rewrite limit passes the rest through",
+			setup: func(cfg *config.Config) { cfg.Limits.MaxRewritesPerResponse = 2 },
+			in:    "https://github.com/a/b/raw/main/1.sh\nhttps://github.com/a/b/raw/main/2.sh https://github.com/a/b/raw/main/3.sh\nhttps://github.com/a/b/raw/main/4.sh\n",
+			want:  "https://proxy.example/https://github.com/a/b/raw/main/1.sh\nhttps://proxy.example/https://github.com/a/b/raw/main/2.sh https://github.com/a/b/raw/main/3.sh\nhttps://github.com/a/b/raw/main/4.sh\n",
+		},
+		{
+			name:  "unchanged links do not count towards the limit",
+			setup: func(cfg *config.Config) { cfg.Limits.MaxRewritesPerResponse = 1 },
+			in:    "https://example.com/x.sh\nhttps://github.com/a/b/raw/main/1.sh\n",
+			want:  "https://example.com/x.sh\nhttps://proxy.example/https://github.com/a/b/raw/main/1.sh\n",
+		},
+		{
+			name:   "banner after shebang",
+			in:     "#!/bin/sh\necho hi\n",
+			banner: "# Served via ghproxy",
+			want:   "#!/bin/sh\n# Served via ghproxy\necho hi\n",
+		},
+		{
+			name:   "banner without shebang",
+			in:     "echo hi\n",
+			banner: "# Served via ghproxy",
+			want:   "# Served via ghproxy\necho hi\n",
+		},
+		{
+			name:   "banner after lone shebang without newline",
+			in:     "#!/bin/sh",
+			banner: "# Served via ghproxy",
+			want:   "#!/bin/sh\n# Served via ghproxy\n",
+		},
+		{
+			name:   "banner on empty body",
+			banner: "# Served via ghproxy",
+			want:   "# Served via ghproxy\n",
+		},
+		{
+			name: "markdown images across hosts",
+			in:   "![logo](https://raw.githubusercontent.com/user/repo/main/logo.png) ![shot](https://user-images.githubusercontent.com/1/a.png)\n",
+			want: "![logo](https://proxy.example/https://raw.githubusercontent.com/user/repo/main/logo.png) ![shot](https://proxy.example/https://user-images.githubusercontent.com/1/a.png)\n",
+		},
+		{
+			name: "markdown badge link",
+			in:   "[![ci](https://github.com/user/repo/raw/main/badge.svg)](https://github.com/user/repo/raw/main/README.md).\n",
+			want: "[![ci](https://proxy.example/https://github.com/user/repo/raw/main/badge.svg)](https://proxy.example/https://github.com/user/repo/raw/main/README.md).\n",
+		},
+		{
+			name: "parentheses inside url are kept",
+			in:   "![img](https://github.com/user/repo/raw/main/a_(1).png)\n",
+			want: "![img](https://proxy.example/https://github.com/user/repo/raw/main/a_(1).png)\n",
+		},
+		{
+			name:  "long line split at delimiters",
+			setup: func(cfg *config.Config) { cfg.Limits.MaxLineBytes = 64 },
+			in:    strings.Repeat("https://github.com/user/repo/raw/main/install.sh ", 4) + "\n",
+			want:  strings.Repeat("https://proxy.example/https://github.com/user/repo/raw/main/install.sh ", 4) + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			reader, _, err := processLinks(io.NopCloser(strings.NewReader(tt.in)), "", "", "proxy.example", cfg, false, tt.banner, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipRewrite(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Shell.SkipRewritePatterns = []string{`\.min\.(js|css)$`, `^/user/repo/raw/main/vendor/`}
+	if err := compileSkipRewritePatterns(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { skipRewritePatterns = nil })
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{url: "https://github.com/user/repo/raw/main/dist/app.min.js", want: true},
+		{url: "https://raw.githubusercontent.com/user/repo/main/dist/app.min.css?v=1", want: true},
+		{url: "https://github.com/user/repo/raw/main/vendor/install.sh", want: true},
+		{url: "https://github.com/user/repo/raw/main/dist/app.js"},
+		{url: "https://github.com/user/repo/raw/main/install.sh#app.min.js"},
+		{url: "https://raw.githubusercontent.com/user/repo/main/vendor/install.sh"},
+	}
+	for _, tt := range tests {
+		if got := skipRewrite(tt.url); got != tt.want {
+			t.Errorf("skipRewrite(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+
+	cfg.Shell.SkipRewritePatterns = []string{`(`}
+	if err := compileSkipRewritePatterns(cfg); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+// 逐行刷新时, 首行在后续内容到达前即可读出
+func TestProcessLinksFlushPerLine(t *testing.T) {
+	for _, outCompress := range []string{"", "gzip"} {
+		t.Run("out="+outCompress, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Shell.FlushPerLine = true
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			reader, _, err := processLinks(pr, "", outCompress, "proxy.example", cfg, false, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 逐字节读取首行, 上游管道保持打开
+			line := make(chan string, 1)
+			go func() {
+				if outCompress == "gzip" {
+					gz, err := gzip.NewReader(reader)
+					if err != nil {
+						line <- err.Error()
+						return
+					}
+					reader = gz
+				}
+				var sb strings.Builder
+				buf := make([]byte, 1)
+				for !strings.HasSuffix(sb.String(), "\n") {
+					if _, err := reader.Read(buf); err != nil {
+						break
+					}
+					sb.Write(buf)
+				}
+				line <- sb.String()
+			}()
+			if _, err := io.WriteString(pw, "data: https://github.com/user/repo/raw/main/a.sh\n"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-line:
+				if want := "data: https://proxy.example/https://github.com/user/repo/raw/main/a.sh\n"; got != want {
+					t.Errorf("first line = %q, want %q", got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("first line was not flushed")
+			}
+		})
+	}
+}
+
+func TestProcessLinksProgress(t *testing.T) {
+	var sb strings.Builder
+	for sb.Len() < 4*int(progressInterval) {
+		sb.WriteString("curl -fsSL https://github.com/user/repo/raw/main/install.sh | bash\n")
+	}
+	var calls []int64
+	reader, _, err := processLinks(io.NopCloser(strings.NewReader(sb.String())), "", "", "proxy.example", config.DefaultConfig(), false, "", func(written int64) {
+		calls = append(calls, written)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) < 4 {
+		t.Fatalf("got %d progress callbacks, want at least 4: %v", len(calls), calls)
+	}
+	// 过程中的回调间隔至少 progressInterval, 结束时以总量回调
+	for i := 0; i < len(calls)-1; i++ {
+		prev := int64(0)
+		if i > 0 {
+			prev = calls[i-1]
+		}
+		if calls[i]-prev < progressInterval {
+			t.Errorf("callback %d at %d bytes, less than %d after previous %d", i, calls[i], progressInterval, prev)
+		}
+	}
+	if last := calls[len(calls)-1]; last != int64(len(out)) {
+		t.Errorf("last callback = %d, want output size %d", last, len(out))
+	}
+}
+
+func TestMatchRawPathSensitiveSubpaths(t *testing.T) {
+	cfg := config.DefaultConfig()
+	for subpath := range sensitiveSubpaths {
+		for _, u := range []string{
+			"https://github.com/" + subpath,
+			"https://github.com/" + subpath + "/profile",
+			"https://github.com/user/repo/" + subpath,
+			"https://github.com/user/repo/" + subpath + "/advisories/GHSA-xxxx-xxxx-xxxx",
+		} {
+			t.Run(u, func(t *testing.T) {
+				result, errInfo := matchRawPath(u, cfg)
+				if errInfo == nil || errInfo.StatusCode != 403 {
+					t.Fatalf("matchRawPath(%q) = %+v, %v; want 403", u, result, errInfo)
+				}
+				if !strings.Contains(errInfo.ErrorMessage, subpath) {
+					t.Errorf("message = %q, want it to name %q", errInfo.ErrorMessage, subpath)
+				}
+			})
+		}
+	}
+}
+
+// 各边界段数下, 匹配成功时必须带有已定义的matcher
+func TestMatchRawPathNeverEmptyMatcher(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Shell.EnableCDNPaths = true
+	cfg.Upstream.AllowPackages = true
+	cfg.Upstream.AllowFileFinder = true
+	hosts := []string{
+		"https://github.com",
+		"https://raw.githubusercontent.com",
+		"https://raw.githubusercontent.com/gist",
+		"https://gist.github.com",
+		"https://gist.githubusercontent.com",
+		"https://api.github.com",
+		"https://api.github.com/repos",
+		"https://api.github.com/users",
+		"https://codeload.github.com",
+		"https://npm.pkg.github.com",
+		"https://gh",
+	}
+	sections := []string{"", "raw", "blob", "tree", "blame", "find", "search", "releases", "archive", "info", "objects", "HEAD", "unknown"}
+	var urls []string
+	for _, host := range hosts {
+		urls = append(urls, host, host+"/", host+"/user", host+"/user/", host+"/user/repo", host+"/user/repo/", host+"/user/repo.git")
+		for _, section := range sections {
+			base := host + "/user/repo/" + section
+			urls = append(urls, base, base+"/", base+"/main", base+"/main/", base+"/main/a.sh", base+"?x=1")
+		}
+	}
+	for _, u := range urls {
+		result, errInfo := matchRawPath(u, cfg)
+		if errInfo != nil {
+			continue
+		}
+		if result == nil || !result.Matcher.IsValid() {
+			t.Errorf("matchRawPath(%q) = %+v with nil error, want a defined matcher", u, result)
+		}
+	}
+}
+
+func TestDiagnoseMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		wantMatcher MatcherType
+		wantStatus  int      // 非0时期望匹配失败
+		wantUser    string   // 出错时保留的部分结果
+		wantTrace   []string // 期望trace中依次包含的分支
+	}{
+		{name: "github raw", url: "https://github.com/user/repo/raw/main/a.sh", wantMatcher: MatcherRaw,
+			wantTrace: []string{"github.com"}},
+		{name: "raw host", url: "https://raw.githubusercontent.com/user/repo/main/a.sh", wantMatcher: MatcherRaw,
+			wantTrace: []string{"github.com", "raw"}},
+		{name: "codeload", url: "https://codeload.github.com/user/repo/tar.gz/main", wantMatcher: MatcherReleases,
+			wantTrace: []string{"github.com", "raw", "gist.github.com", "codeload.github.com"}},
+		{name: "unmatched", url: "https://example.com/user/repo", wantStatus: 404,
+			wantTrace: []string{"github.com", "raw", "api.github.com", "codeload.github.com"}},
+		{name: "partial", url: "https://github.com/user/repo/pulls", wantStatus: 400, wantUser: "user",
+			wantTrace: []string{"github.com"}},
+		{name: "control chars", url: "https://github.com/user/repo/raw/main/a%0a.sh", wantStatus: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errInfo, trace := DiagnoseMatch(tt.url, config.DefaultConfig())
+			if tt.wantStatus != 0 {
+				if errInfo == nil || errInfo.StatusCode != tt.wantStatus {
+					t.Fatalf("DiagnoseMatch(%q) error = %+v, want status %d", tt.url, errInfo, tt.wantStatus)
+				}
+				if result.User != tt.wantUser {
+					t.Errorf("partial user = %q, want %q", result.User, tt.wantUser)
+				}
+				if result.Parsed != nil {
+					t.Errorf("Parsed = %v on error, want nil", result.Parsed)
+				}
+			} else {
+				if errInfo != nil {
+					t.Fatalf("DiagnoseMatch(%q) unexpected error: %+v", tt.url, errInfo)
+				}
+				if result.Matcher != tt.wantMatcher {
+					t.Errorf("Matcher = %q, want %q", result.Matcher, tt.wantMatcher)
+				}
+				if result.Parsed == nil || result.Parsed.String() != result.URL {
+					t.Errorf("Parsed = %v, want parsed %q", result.Parsed, result.URL)
+				}
+			}
+			// trace 需按顺序包含期望的分支
+			i := 0
+			for _, branch := range trace {
+				if i < len(tt.wantTrace) && branch == tt.wantTrace[i] {
+					i++
+				}
+			}
+			if i != len(tt.wantTrace) {
+				t.Errorf("trace = %v, want subsequence %v", trace, tt.wantTrace)
+			}
+		})
+	}
+}
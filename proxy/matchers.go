@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"ghproxy/config"
+	"sort"
+)
+
+// MatcherType Matcher的分类结果
+type MatcherType string
+
+const (
+	MatcherReleases MatcherType = "releases"
+	MatcherBlob     MatcherType = "blob"
+	MatcherRaw      MatcherType = "raw"
+	MatcherTree     MatcherType = "tree"
+	MatcherGist     MatcherType = "gist"
+	MatcherAPI      MatcherType = "api"
+	MatcherClone    MatcherType = "clone"
+	MatcherLFS      MatcherType = "lfs"
+	MatcherPackages MatcherType = "packages"
+	MatcherObject   MatcherType = "object"
+	MatcherFind     MatcherType = "find"
+	MatcherBlame    MatcherType = "blame"
+)
+
+// AllMatchers 全部matcher类型
+var AllMatchers = []MatcherType{
+	MatcherReleases,
+	MatcherBlob,
+	MatcherRaw,
+	MatcherTree,
+	MatcherGist,
+	MatcherAPI,
+	MatcherClone,
+	MatcherLFS,
+	MatcherPackages,
+	MatcherObject,
+	MatcherFind,
+	MatcherBlame,
+}
+
+// IsValid 判断是否为已定义的matcher类型
+func (m MatcherType) IsValid() bool {
+	return m.In(AllMatchers)
+}
+
+// In 判断matcher是否位于给定列表中
+func (m MatcherType) In(matchers []MatcherType) bool {
+	for _, matcher := range matchers {
+		if m == matcher {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMatcherNames 校验配置中以matcher名称引用的列表与表, 存在未定义的名称时返回错误
+// 拼写错误的matcher不会匹配任何请求, 相应配置会静默失效, 因此在启动时拒绝
+func validateMatcherNames(cfg *config.Config) error {
+	options := []struct {
+		option string
+		names  []string
+	}{
+		{"disabled", cfg.Disabled},
+		{"shell.redirectMatchers", cfg.Shell.RedirectMatchers},
+		{"server.responseHeaderPolicy.matchers", mapKeys(cfg.Server.ResponseHeaderPolicy.Matchers)},
+		{"upstream.matcherMaxRedirects", mapKeys(cfg.Upstream.MatcherMaxRedirects)},
+		{"limits.matcherMaxRequestBodyBytes", mapKeys(cfg.Limits.MatcherMaxRequestBodyBytes)},
+	}
+	for _, opt := range options {
+		for _, name := range opt.names {
+			if !MatcherType(name).IsValid() {
+				return fmt.Errorf("unknown matcher %q in %s, valid matchers: %v", name, opt.option, AllMatchers)
+			}
+		}
+	}
+	return nil
+}
+
+// mapKeys 返回排序后的键, 保证报错顺序稳定
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
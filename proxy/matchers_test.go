@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"ghproxy/config"
+)
+
+func TestValidateMatcherNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *config.Config)
+		wantErr string // 为空时期望通过
+	}{
+		{name: "defaults", modify: func(cfg *config.Config) {}},
+		{name: "valid names", modify: func(cfg *config.Config) {
+			cfg.Disabled = []string{"gist", "lfs"}
+			cfg.Shell.RedirectMatchers = []string{"releases"}
+			cfg.Upstream.MatcherMaxRedirects = map[string]int{"clone": 3}
+			cfg.Limits.MatcherMaxRequestBodyBytes = map[string]int64{"api": 1024}
+			cfg.Server.ResponseHeaderPolicy.Matchers = map[string]config.HeaderPolicyConfig{"raw": {}}
+		}},
+		{name: "disabled", modify: func(cfg *config.Config) {
+			cfg.Disabled = []string{"gist", "gists"}
+		}, wantErr: `"gists" in disabled`},
+		{name: "redirect matchers", modify: func(cfg *config.Config) {
+			cfg.Shell.RedirectMatchers = []string{"Releases"}
+		}, wantErr: `"Releases" in shell.redirectMatchers`},
+		{name: "max redirects", modify: func(cfg *config.Config) {
+			cfg.Upstream.MatcherMaxRedirects = map[string]int{"clone": 3, "git": 3}
+		}, wantErr: `"git" in upstream.matcherMaxRedirects`},
+		{name: "max request body", modify: func(cfg *config.Config) {
+			cfg.Limits.MatcherMaxRequestBodyBytes = map[string]int64{"upload": 1}
+		}, wantErr: `"upload" in limits.matcherMaxRequestBodyBytes`},
+		{name: "header policy", modify: func(cfg *config.Config) {
+			cfg.Server.ResponseHeaderPolicy.Matchers = map[string]config.HeaderPolicyConfig{"blobs": {}}
+		}, wantErr: `"blobs" in server.responseHeaderPolicy.matchers`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			tt.modify(cfg)
+			err := validateMatcherNames(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// errPrivateIP 上游解析到内网/回环/链路本地地址
+var errPrivateIP = errors.New("upstream resolved to a private address")
+
+// isPrivateIP 判断是否为RFC1918/回环/链路本地等非公网地址
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// privateIPControl 在DNS解析完成、建立连接前校验目标地址, 防止DNS重绑定到内网
+// 返回给客户端的错误不包含解析得到的地址, 地址仅记录在日志中
+func privateIPControl(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateIP(ip) {
+		logWarning("Upstream dial to private address blocked: %s", host)
+		recordUpstreamError(ctx, errPrivateIP)
+		return fmt.Errorf("%w: %s", errPrivateIP, host)
+	}
+	return nil
+}
+
+// applyPrivateIPBlock 按 config.Upstream.BlockPrivateIPs 为transport设置校验目标地址的拨号器
+// 使用出站代理时连接目标为代理本身, 地址由代理解析, 不做校验
+func applyPrivateIPBlock(cfg *config.Config, transport *http.Transport) {
+	if !cfg.Upstream.BlockPrivateIPs {
+		return
+	}
+	if cfg.Outbound.Enabled {
+		logWarning("upstream.blockPrivateIPs is ignored when outbound proxy is enabled")
+		return
+	}
+	dialer := &net.Dialer{
+		Timeout:        30 * time.Second,
+		KeepAlive:      30 * time.Second,
+		ControlContext: privateIPControl,
+	}
+	transport.DialContext = dialer.DialContext
+}
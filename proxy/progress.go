@@ -0,0 +1,19 @@
+package proxy
+
+// ProgressFunc 在流式改写过程中回调已输出的字节数
+type ProgressFunc func(written int64)
+
+// 两次进度回调之间的最小输出字节数, 避免逐行回调的开销
+const progressInterval int64 = 64 * 1024
+
+func noopProgress(written int64) {}
+
+var progressFunc ProgressFunc = noopProgress
+
+// SetProgressFunc 注册改写响应的进度回调, 传入nil恢复默认实现
+func SetProgressFunc(f ProgressFunc) {
+	if f == nil {
+		f = noopProgress
+	}
+	progressFunc = f
+}
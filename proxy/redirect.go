@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errTooManyRedirects 上游重定向次数超过 MaxRedirects
+var errTooManyRedirects = errors.New("too many upstream redirects")
+
+// errRedirectHostNotAllowed 上游重定向至 RedirectAllowedHosts 以外的host
+var errRedirectHostNotAllowed = errors.New("upstream redirect host not allowed")
+
+// 允许跟随重定向的host, 启动时由 setRedirectAllowedHosts 设置, 为空时不限制
+var redirectAllowedHosts []string
+
+// setRedirectAllowedHosts 读取 config.Upstream.RedirectAllowedHosts
+func setRedirectAllowedHosts(cfg *config.Config) {
+	hosts := make([]string, 0, len(cfg.Upstream.RedirectAllowedHosts))
+	for _, host := range cfg.Upstream.RedirectAllowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	redirectAllowedHosts = hosts
+}
+
+// redirectHostAllowed 判断重定向目标host是否在允许列表内, 支持 "*.example.com" 匹配子域名
+func redirectHostAllowed(host string) bool {
+	if len(redirectAllowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range redirectAllowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+type redirectLimitKey struct{}
+
+// withRedirectLimit 将本次请求允许的最大重定向次数写入context, limit<=0时不限制
+func withRedirectLimit(ctx context.Context, limit int) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, redirectLimitKey{}, limit)
+}
+
+// redirectLimitFor 返回matcher对应的最大重定向次数, 按matcher的配置优先
+func redirectLimitFor(matcher MatcherType, cfg *config.Config) int {
+	if limit, ok := cfg.Upstream.MatcherMaxRedirects[string(matcher)]; ok {
+		return limit
+	}
+	return cfg.Upstream.MaxRedirects
+}
+
+// redirectCount 通过 req.Response 链计算当前请求是第几次重定向
+func redirectCount(req *http.Request) int {
+	n := 0
+	for r := req; r != nil && r.Response != nil; r = r.Response.Request {
+		n++
+	}
+	return n
+}
+
+// redirectLimitProxy 包装 Transport.Proxy, 在每次发出请求(含重定向)前检查重定向次数与目标host
+// httpc 未暴露 http.Client.CheckRedirect, 因此在 Transport 层进行检查
+// 重定向均在服务端跟随, 中间及最终的 Location 不会返回给客户端
+func redirectLimitProxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		count := redirectCount(req)
+		if limit, ok := req.Context().Value(redirectLimitKey{}).(int); ok && count > limit {
+			return nil, recordUpstreamError(req.Context(), fmt.Errorf("%w (limit %d)", errTooManyRedirects, limit))
+		}
+		if count > 0 && !redirectHostAllowed(req.URL.Hostname()) {
+			return nil, recordUpstreamError(req.Context(), fmt.Errorf("%w: %s", errRedirectHostNotAllowed, req.URL.Hostname()))
+		}
+		if next == nil {
+			return nil, nil
+		}
+		return next(req)
+	}
+}
@@ -3,6 +3,7 @@ package proxy
 import (
 	"ghproxy/config"
 	"net/http"
+	"strings"
 
 	"github.com/cloudwego/hertz/pkg/app"
 )
@@ -18,27 +19,34 @@ var (
 		"X-Fastly-Request-Id":       {},
 	}
 
+	// 请求头的键为规范化形式, 与hertz VisitAll 返回的header名一致(如 Cf-Connecting-Ip)
 	reqHeadersToRemove = map[string]struct{}{
-		"CF-IPCountry":     {},
-		"CF-RAY":           {},
-		"CF-Visitor":       {},
-		"CF-Connecting-IP": {},
-		"CF-EW-Via":        {},
-		"CDN-Loop":         {},
+		"Cf-Ipcountry":     {},
+		"Cf-Ray":           {},
+		"Cf-Visitor":       {},
+		"Cf-Connecting-Ip": {},
+		"Cf-Ew-Via":        {},
+		"Cdn-Loop":         {},
 		"Upgrade":          {},
 		"Connection":       {},
 	}
 
 	cloneHeadersToRemove = map[string]struct{}{
-		"CF-IPCountry":     {},
-		"CF-RAY":           {},
-		"CF-Visitor":       {},
-		"CF-Connecting-IP": {},
-		"CF-EW-Via":        {},
-		"CDN-Loop":         {},
+		"Cf-Ipcountry":     {},
+		"Cf-Ray":           {},
+		"Cf-Visitor":       {},
+		"Cf-Connecting-Ip": {},
+		"Cf-Ew-Via":        {},
+		"Cdn-Loop":         {},
 	}
 )
 
+// 条件请求头
+var conditionalHeaders = []string{
+	"If-None-Match",
+	"If-Modified-Since",
+}
+
 // 预定义headers
 var (
 	defaultHeaders = map[string]string{
@@ -49,17 +57,23 @@ var (
 	}
 )
 
-func setRequestHeaders(c *app.RequestContext, req *http.Request, cfg *config.Config, matcher string) {
-	if matcher == "raw" && cfg.Httpc.UseCustomRawHeaders {
+func setRequestHeaders(c *app.RequestContext, req *http.Request, cfg *config.Config, matcher MatcherType) {
+	if matcher == MatcherRaw && cfg.Httpc.UseCustomRawHeaders {
 		// 使用预定义Header
 		for key, value := range defaultHeaders {
 			req.Header.Set(key, value)
 		}
-	} else if matcher == "clone" {
+		// 条件请求头仍需转发, 以便上游返回304
+		for _, key := range conditionalHeaders {
+			if value := c.Request.Header.Get(key); value != "" {
+				req.Header.Set(key, value)
+			}
+		}
+	} else if matcher == MatcherClone {
 		c.Request.Header.VisitAll(func(key, value []byte) {
 			headerKey := string(key)
 			headerValue := string(value)
-			if _, shouldRemove := cloneHeadersToRemove[headerKey]; !shouldRemove {
+			if _, shouldRemove := cloneHeadersToRemove[http.CanonicalHeaderKey(headerKey)]; !shouldRemove {
 				req.Header.Set(headerKey, headerValue)
 			}
 		})
@@ -67,9 +81,38 @@ func setRequestHeaders(c *app.RequestContext, req *http.Request, cfg *config.Con
 		c.Request.Header.VisitAll(func(key, value []byte) {
 			headerKey := string(key)
 			headerValue := string(value)
-			if _, shouldRemove := reqHeadersToRemove[headerKey]; !shouldRemove {
+			if _, shouldRemove := reqHeadersToRemove[http.CanonicalHeaderKey(headerKey)]; !shouldRemove {
 				req.Header.Set(headerKey, headerValue)
 			}
 		})
+		// api 依赖 Accept 协商媒体类型, 客户端的值原样转发, 未携带时注入默认值
+		if matcher == MatcherAPI && cfg.Httpc.APIDefaultAccept != "" && req.Header.Get("Accept") == "" {
+			req.Header.Set("Accept", cfg.Httpc.APIDefaultAccept)
+		}
+	}
+	// 私有仓库克隆: 未启用时不转发Basic凭据, lfs 对象下载由 git 携带相同的凭据, 一并处理
+	if (matcher == MatcherClone || matcher == MatcherLFS) && !cfg.Auth.AllowPrivateClone && isBasicAuth(req.Header.Get("Authorization")) {
+		req.Header.Del("Authorization")
+		logDebug("%s %s %s Basic credentials dropped, allowPrivateClone is disabled", c.ClientIP(), c.Method(), c.Path())
 	}
 }
+
+func isBasicAuth(value string) bool {
+	return len(value) >= 6 && strings.EqualFold(value[:6], "Basic ")
+}
+
+// redactedHeaders 返回用于日志的请求头, 隐藏凭据
+func redactedHeaders(c *app.RequestContext) string {
+	var sb strings.Builder
+	c.Request.Header.VisitAll(func(key, value []byte) {
+		sb.Write(key)
+		sb.WriteString(": ")
+		if strings.EqualFold(string(key), "Authorization") {
+			sb.WriteString("[REDACTED]")
+		} else {
+			sb.Write(value)
+		}
+		sb.WriteString("\r\n")
+	})
+	return sb.String()
+}
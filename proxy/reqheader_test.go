@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSetRequestHeadersBasicAuth(t *testing.T) {
+	const basic = "Basic dXNlcjpwYXNz"
+	tests := []struct {
+		name              string
+		matcher           MatcherType
+		authorization     string
+		allowPrivateClone bool
+		want              string
+	}{
+		{name: "clone disabled", matcher: MatcherClone, authorization: basic},
+		{name: "clone enabled", matcher: MatcherClone, authorization: basic, allowPrivateClone: true, want: basic},
+		{name: "lfs disabled", matcher: MatcherLFS, authorization: basic},
+		{name: "lfs enabled", matcher: MatcherLFS, authorization: basic, allowPrivateClone: true, want: basic},
+		{name: "clone bearer kept", matcher: MatcherClone, authorization: "Bearer token", want: "Bearer token"},
+		{name: "lfs bearer kept", matcher: MatcherLFS, authorization: "Bearer token", want: "Bearer token"},
+		{name: "api basic kept", matcher: MatcherAPI, authorization: basic, want: basic},
+		{name: "packages token forwarded", matcher: MatcherPackages, authorization: "Bearer ghp_token", want: "Bearer ghp_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Auth.AllowPrivateClone = tt.allowPrivateClone
+			c := newTestRequestContext("GET")
+			c.Request.Header.Set("Authorization", tt.authorization)
+			req, err := http.NewRequest("GET", "https://github.com/user/repo.git/info/lfs/objects/batch", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			setRequestHeaders(c, req, cfg, tt.matcher)
+
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 客户端的协商头原样转发, CDN附加的头不转发
+func TestSetRequestHeadersForwarding(t *testing.T) {
+	tests := []struct {
+		matcher MatcherType
+		accept  string
+	}{
+		{matcher: MatcherClone, accept: "application/x-git-upload-pack-advertisement"},
+		{matcher: MatcherAPI, accept: "application/vnd.github+json"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.matcher), func(t *testing.T) {
+			c := newTestRequestContext("GET")
+			c.Request.Header.Set("Git-Protocol", "version=2")
+			c.Request.Header.Set("Accept", tt.accept)
+			c.Request.Header.Set("CF-Connecting-IP", "203.0.113.1")
+			c.Request.Header.Set("CF-RAY", "abc")
+			c.Request.Header.Set("CDN-Loop", "cloudflare")
+			req, err := http.NewRequest("GET", "https://github.com/user/repo.git/info/refs", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			setRequestHeaders(c, req, proxyTestConfig(), tt.matcher)
+
+			for key, want := range map[string]string{
+				"Git-Protocol":     "version=2",
+				"Accept":           tt.accept,
+				"CF-Connecting-IP": "",
+				"CF-RAY":           "",
+				"CDN-Loop":         "",
+			} {
+				if got := req.Header.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -19,6 +19,11 @@ func RoutingHandler(cfg *config.Config, limiter *rate.RateLimiter, iplimiter *ra
 			return
 		}
 
+		shoudBreak = upgradeCheck(c)
+		if shoudBreak {
+			return
+		}
+
 		var (
 			rawPath string
 		)
@@ -28,40 +33,82 @@ func RoutingHandler(cfg *config.Config, limiter *rate.RateLimiter, iplimiter *ra
 		var (
 			user    string
 			repo    string
-			matcher string
+			matcher MatcherType
 		)
 
-		user = c.Param("user")
-		repo = c.Param("repo")
-		matcher = c.GetString("matcher")
+		// 与NoRouteHandler共用matcher, 保证两个入口的校验(控制字符、ref、release、archive等)一致
+		result, errInfo := matchRawPath("https://"+rawPath, cfg)
+		if errInfo != nil {
+			if result != nil {
+				logDebug("%s %s %s %s %s Matcher-Error: %s, Matched-Username: %s, Matched-Repo: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), errInfo.ErrorMessage, result.User, result.Repo)
+			}
+			auditReject(cfg, c, rawPath, result, errInfo)
+			ErrorPage(c, errInfo)
+			return
+		}
+		user = result.User
+		repo = result.Repo
+		matcher = result.Matcher
+		rawPath = result.URL
+		c.Set("matcher", string(matcher))
+		c.Set("ref", result.Ref)
 
 		logDump("%s %s %s %s %s Matched-Username: %s, Matched-Repo: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), user, repo)
-		logDump("%s", c.Request.Header.Header())
+		logDump("%s", redactedHeaders(c))
+
+		shoudBreak = disabledCheck(cfg, c, matcher, user, repo, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = headerSizeCheck(cfg, c, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = bodySizeCheck(cfg, c, matcher, rawPath)
+		if shoudBreak {
+			return
+		}
 
 		shoudBreak = listCheck(cfg, c, user, repo, rawPath)
 		if shoudBreak {
 			return
 		}
 
-		shoudBreak = authCheck(c, cfg, matcher, rawPath)
+		result.parseURL()
+		GlobalStats.IncMatcher(string(matcher))
+		shoudBreak = refCheck(cfg, c, result, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = authCheck(c, cfg, result, rawPath)
+		if shoudBreak {
+			return
+		}
+
+		shoudBreak = validateCheck(c, result, rawPath)
 		if shoudBreak {
 			return
 		}
 
 		// 处理blob/raw路径
-		if matcher == "blob" {
+		if matcher == MatcherBlob {
 			rawPath = strings.Replace(rawPath, "/blob/", "/raw/", 1)
 		}
 
-		// 为rawpath加入https:// 头
-		rawPath = "https://" + rawPath
-
 		logDebug("Matched: %v", matcher)
 
+		shoudBreak = redirectCheck(cfg, c, matcher, rawPath)
+		if shoudBreak {
+			return
+		}
+
 		switch matcher {
-		case "releases", "blob", "raw", "gist", "api":
+		case MatcherReleases, MatcherBlob, MatcherRaw, MatcherTree, MatcherGist, MatcherAPI, MatcherLFS, MatcherPackages, MatcherObject, MatcherFind, MatcherBlame:
 			ChunkedProxyRequest(ctx, c, rawPath, cfg, matcher)
-		case "clone":
+		case MatcherClone:
 			GitReq(ctx, c, rawPath, cfg, "git")
 		default:
 			ErrorPage(c, NewErrorWithStatusLookup(500, "Matched But Not Matched"))
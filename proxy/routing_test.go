@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/route/param"
+)
+
+// newRouteContext 模拟 main.go 中 /github.com/:user/:repo/<kind>/*filepath 路由匹配后的上下文
+func newRouteContext(kind, user, repo, filepath string) *app.RequestContext {
+	c := app.NewContext(0)
+	c.Request.Header.SetMethod("GET")
+	c.Request.SetRequestURI("/github.com/" + user + "/" + repo + "/" + kind + filepath)
+	c.SetFullPath("/github.com/:user/:repo/" + kind + "/*filepath")
+	c.Params = param.Params{
+		{Key: "user", Value: user},
+		{Key: "repo", Value: repo},
+		{Key: "filepath", Value: filepath},
+	}
+	return c
+}
+
+// stopAtValidator 记录通过全部检查后的匹配结果, 并以403终止请求, 避免访问上游
+func stopAtValidator(t *testing.T) **MatchResult {
+	t.Helper()
+	var captured *MatchResult
+	SetURLValidator(func(result *MatchResult, u *url.URL) *GHProxyErrors {
+		captured = result
+		return NewErrorWithStatusLookup(403, "stop")
+	})
+	t.Cleanup(func() { SetURLValidator(nil) })
+	return &captured
+}
+
+func TestRoutingHandlerReleases(t *testing.T) {
+	tests := []struct {
+		name       string
+		filepath   string
+		wantStatus int
+		wantTag    string
+		wantAsset  string
+	}{
+		{name: "download", filepath: "/download/v1.0/app.tar.gz", wantStatus: 403, wantTag: "v1.0", wantAsset: "app.tar.gz"},
+		{name: "latest download", filepath: "/latest/download/app.zip", wantStatus: 403, wantTag: "latest", wantAsset: "app.zip"},
+		{name: "download without asset", filepath: "/download/v1.0", wantStatus: 400},
+		{name: "download with extra segment", filepath: "/download/v1.0/dir/app.zip", wantStatus: 400},
+		{name: "latest without asset", filepath: "/latest/download/", wantStatus: 400},
+		{name: "release page", filepath: "/tag/v1.0", wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured := stopAtValidator(t)
+			c := newRouteContext("releases", "user", "repo", tt.filepath)
+			c.Set("matcher", string(MatcherReleases))
+
+			RoutingHandler(proxyTestConfig(), nil, nil)(context.Background(), c)
+
+			if status := c.Response.StatusCode(); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+			if tt.wantStatus != 403 {
+				return
+			}
+			if *captured == nil {
+				t.Fatal("validator was not reached")
+			}
+			if (*captured).Tag != tt.wantTag || (*captured).Asset != tt.wantAsset {
+				t.Errorf("Tag/Asset = %q/%q, want %q/%q", (*captured).Tag, (*captured).Asset, tt.wantTag, tt.wantAsset)
+			}
+		})
+	}
+}
+
+// 路由入口与NoRouteHandler共用matcher的校验与字段提取
+func TestRoutingHandlerSharedMatcher(t *testing.T) {
+	tests := []struct {
+		name        string
+		kind        string
+		filepath    string
+		wantStatus  int
+		wantMatcher MatcherType
+		wantRef     string
+		wantFormat  string
+	}{
+		{name: "control characters", kind: "raw", filepath: "/main/a%00.sh", wantStatus: 400},
+		{name: "blob raw query", kind: "blob", filepath: "/main/a.sh?raw=true", wantStatus: 403, wantMatcher: MatcherRaw, wantRef: "main"},
+		{name: "archive", kind: "archive", filepath: "/refs/tags/v1.0.tar.gz", wantStatus: 403, wantMatcher: MatcherReleases, wantRef: "refs/tags/v1.0", wantFormat: "tar.gz"},
+		{name: "invalid archive", kind: "archive", filepath: "/v1.0.rar", wantStatus: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured := stopAtValidator(t)
+			c := newRouteContext(tt.kind, "user", "repo", tt.filepath)
+			c.Set("matcher", string(MatcherRaw))
+
+			RoutingHandler(proxyTestConfig(), nil, nil)(context.Background(), c)
+
+			if status := c.Response.StatusCode(); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+			if tt.wantStatus != 403 {
+				if *captured != nil {
+					t.Error("rejected request reached the validator")
+				}
+				return
+			}
+			if *captured == nil {
+				t.Fatal("validator was not reached")
+			}
+			got := *captured
+			if got.Matcher != tt.wantMatcher || got.Ref != tt.wantRef || got.Format != tt.wantFormat {
+				t.Errorf("Matcher/Ref/Format = %s/%q/%q, want %s/%q/%q", got.Matcher, got.Ref, got.Format, tt.wantMatcher, tt.wantRef, tt.wantFormat)
+			}
+		})
+	}
+}
+
+// 匹配成功后 Parsed 需与 URL 一致, 并作为校验器的第二个参数传入
+func TestMatchResultParsed(t *testing.T) {
+	// Matcher 匹配成功时解析上游url, 出错时不解析
+	result, errInfo := Matcher("https://raw.githubusercontent.com/user/repo/main/a.sh", proxyTestConfig())
+	if errInfo != nil || result.Parsed == nil || result.Parsed.String() != result.URL {
+		t.Fatalf("Matcher result = %+v (err %v), want Parsed matching URL", result, errInfo)
+	}
+	result, errInfo = Matcher("https://github.com/user/repo/pulls", proxyTestConfig())
+	if errInfo == nil || result == nil || result.Parsed != nil {
+		t.Errorf("Matcher error result = %+v (err %v), want partial result without Parsed", result, errInfo)
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(c *app.RequestContext)
+		newCtx   func() *app.RequestContext
+		wantHost string
+		wantPath string
+	}{
+		{name: "no route raw", newCtx: func() *app.RequestContext {
+			c := newTestRequestContext("GET")
+			c.Request.SetRequestURI("/https://raw.githubusercontent.com/user/repo/main/a.sh")
+			return c
+		}, handler: func(c *app.RequestContext) {
+			NoRouteHandler(proxyTestConfig(), nil, nil)(context.Background(), c)
+		}, wantHost: "raw.githubusercontent.com", wantPath: "/user/repo/main/a.sh"},
+		{name: "routing releases", newCtx: func() *app.RequestContext {
+			c := newRouteContext("releases", "user", "repo", "/download/v1.0/app.tar.gz")
+			c.Set("matcher", string(MatcherReleases))
+			return c
+		}, handler: func(c *app.RequestContext) {
+			RoutingHandler(proxyTestConfig(), nil, nil)(context.Background(), c)
+		}, wantHost: "github.com", wantPath: "/user/repo/releases/download/v1.0/app.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var captured *MatchResult
+			var capturedURL *url.URL
+			SetURLValidator(func(result *MatchResult, u *url.URL) *GHProxyErrors {
+				captured, capturedURL = result, u
+				return NewErrorWithStatusLookup(403, "stop")
+			})
+			t.Cleanup(func() { SetURLValidator(nil) })
+
+			tt.handler(tt.newCtx())
+
+			if captured == nil || captured.Parsed == nil {
+				t.Fatalf("matched = %+v, want non-nil Parsed", captured)
+			}
+			if capturedURL != captured.Parsed {
+				t.Errorf("validator url = %v, want result.Parsed %v", capturedURL, captured.Parsed)
+			}
+			if captured.Parsed.Host != tt.wantHost || captured.Parsed.Path != tt.wantPath {
+				t.Errorf("Parsed = %s%s, want %s%s", captured.Parsed.Host, captured.Parsed.Path, tt.wantHost, tt.wantPath)
+			}
+			if captured.Parsed.String() != captured.URL {
+				t.Errorf("Parsed = %q, want URL %q", captured.Parsed.String(), captured.URL)
+			}
+		})
+	}
+}
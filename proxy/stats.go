@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats 汇总代理运行统计, 所有更新均为无锁操作
+type Stats struct {
+	bytesRelayed    atomic.Int64
+	urlsRewritten   atomic.Int64
+	matcherRequests sync.Map // matcher -> *atomic.Int64
+	activeStreams   sync.Map // matcher -> *atomic.Int64, 正在传输的响应体数量
+	apiRateLimit    atomic.Pointer[RateLimitInfo]
+}
+
+// RateLimitInfo 最近一次api响应携带的速率限制信息
+type RateLimitInfo struct {
+	Limit     int64     `json:"limit"`
+	Remaining int64     `json:"remaining"`
+	Reset     int64     `json:"reset"` // 重置时间, unix时间戳(秒)
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StatsSnapshot Stats 在某一时刻的副本, 由 /api/stats 以snake_case字段名输出
+type StatsSnapshot struct {
+	BytesRelayed    int64            `json:"bytes_relayed"`
+	URLsRewritten   int64            `json:"urls_rewritten"`
+	MatcherRequests map[string]int64 `json:"matcher_requests"`
+	ActiveStreams   map[string]int64 `json:"active_streams"`
+	APIRateLimit    *RateLimitInfo   `json:"api_rate_limit"` // 尚未收到api响应时为null
+}
+
+// GlobalStats 全局统计
+var GlobalStats = &Stats{}
+
+func (s *Stats) AddBytes(n int64) {
+	s.bytesRelayed.Add(n)
+}
+
+func (s *Stats) AddRewrites(n int64) {
+	s.urlsRewritten.Add(n)
+}
+
+func (s *Stats) IncMatcher(matcher string) {
+	matcherCounter(&s.matcherRequests, matcher).Add(1)
+}
+
+// StreamStarted 增加matcher的活动流数量
+func (s *Stats) StreamStarted(matcher string) {
+	matcherCounter(&s.activeStreams, matcher).Add(1)
+}
+
+// StreamFinished 减少matcher的活动流数量
+func (s *Stats) StreamFinished(matcher string) {
+	matcherCounter(&s.activeStreams, matcher).Add(-1)
+}
+
+// matcherCounter 返回m中matcher对应的计数器, 不存在时创建
+func matcherCounter(m *sync.Map, matcher string) *atomic.Int64 {
+	counter, ok := m.Load(matcher)
+	if !ok {
+		counter, _ = m.LoadOrStore(matcher, new(atomic.Int64))
+	}
+	return counter.(*atomic.Int64)
+}
+
+// SetAPIRateLimit 记录最近一次api响应的速率限制信息
+func (s *Stats) SetAPIRateLimit(info RateLimitInfo) {
+	s.apiRateLimit.Store(&info)
+}
+
+// parseRateLimitHeaders 解析 X-RateLimit-* 响应头, 缺少 X-RateLimit-Remaining 时返回false
+func parseRateLimitHeaders(header http.Header) (RateLimitInfo, bool) {
+	remaining, err := strconv.ParseInt(header.Get("X-RateLimit-Remaining"), 10, 64)
+	if err != nil {
+		return RateLimitInfo{}, false
+	}
+	info := RateLimitInfo{Remaining: remaining, UpdatedAt: time.Now()}
+	info.Limit, _ = strconv.ParseInt(header.Get("X-RateLimit-Limit"), 10, 64)
+	info.Reset, _ = strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	return info, true
+}
+
+// Snapshot 返回当前统计的副本
+func (s *Stats) Snapshot() StatsSnapshot {
+	snapshot := StatsSnapshot{
+		BytesRelayed:    s.bytesRelayed.Load(),
+		URLsRewritten:   s.urlsRewritten.Load(),
+		MatcherRequests: make(map[string]int64),
+		ActiveStreams:   make(map[string]int64),
+	}
+	if info := s.apiRateLimit.Load(); info != nil {
+		rateLimit := *info
+		snapshot.APIRateLimit = &rateLimit
+	}
+	s.matcherRequests.Range(func(key, value any) bool {
+		snapshot.MatcherRequests[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	s.activeStreams.Range(func(key, value any) bool {
+		snapshot.ActiveStreams[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return snapshot
+}
+
+// statsReader 统计透传的字节数
+type statsReader struct {
+	io.ReadCloser
+	stats *Stats
+}
+
+func newStatsReader(rc io.ReadCloser, stats *Stats) io.ReadCloser {
+	return &statsReader{ReadCloser: rc, stats: stats}
+}
+
+func (r *statsReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.stats.AddBytes(int64(n))
+	}
+	return n, err
+}
+
+// streamGauge 在响应体传输期间计入matcher的活动流数量
+// hertz 在响应写出完成或被替换时关闭body stream, 此时减少计数
+type streamGauge struct {
+	io.Reader
+	closer  io.Closer // 底层reader不可关闭时为nil
+	stats   *Stats
+	matcher string
+	closed  atomic.Bool
+}
+
+func newStreamGauge(r io.Reader, stats *Stats, matcher string) io.ReadCloser {
+	closer, _ := r.(io.Closer)
+	stats.StreamStarted(matcher)
+	return &streamGauge{Reader: r, closer: closer, stats: stats, matcher: matcher}
+}
+
+func (g *streamGauge) Close() error {
+	if !g.closed.Swap(true) {
+		g.stats.StreamFinished(g.matcher)
+	}
+	if g.closer == nil {
+		return nil
+	}
+	return g.closer.Close()
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestStatsConcurrent(t *testing.T) {
+	const workers, perWorker = 32, 1000
+	stats := &Stats{}
+	matchers := []string{"raw", "blob", "releases"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			matcher := matchers[i%len(matchers)]
+			for j := 0; j < perWorker; j++ {
+				stats.AddBytes(2)
+				stats.AddRewrites(1)
+				stats.IncMatcher(matcher)
+				stats.StreamStarted(matcher)
+				stats.StreamFinished(matcher)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	snapshot := stats.Snapshot()
+	if want := int64(workers * perWorker * 2); snapshot.BytesRelayed != want {
+		t.Errorf("BytesRelayed = %d, want %d", snapshot.BytesRelayed, want)
+	}
+	if want := int64(workers * perWorker); snapshot.URLsRewritten != want {
+		t.Errorf("URLsRewritten = %d, want %d", snapshot.URLsRewritten, want)
+	}
+	var total int64
+	for matcher, n := range snapshot.MatcherRequests {
+		total += n
+		if active := snapshot.ActiveStreams[matcher]; active != 0 {
+			t.Errorf("ActiveStreams[%s] = %d, want 0", matcher, active)
+		}
+	}
+	if want := int64(workers * perWorker); total != want {
+		t.Errorf("sum of MatcherRequests = %d, want %d", total, want)
+	}
+}
+
+func TestStatsSnapshotJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		rateLimit bool
+		wantKeys  []string
+	}{
+		{name: "without rate limit", wantKeys: []string{"active_streams", "api_rate_limit", "bytes_relayed", "matcher_requests", "urls_rewritten"}},
+		{name: "with rate limit", rateLimit: true, wantKeys: []string{"active_streams", "api_rate_limit", "bytes_relayed", "matcher_requests", "urls_rewritten"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &Stats{}
+			stats.IncMatcher("raw")
+			if tt.rateLimit {
+				header := http.Header{}
+				header.Set("X-RateLimit-Remaining", "10")
+				info, _ := parseRateLimitHeaders(header)
+				stats.SetAPIRateLimit(info)
+			}
+			data, err := json.Marshal(stats.Snapshot())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, 0, len(fields))
+			for k := range fields {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if len(keys) != len(tt.wantKeys) {
+				t.Fatalf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			for i := range keys {
+				if keys[i] != tt.wantKeys[i] {
+					t.Fatalf("keys = %v, want %v", keys, tt.wantKeys)
+				}
+			}
+			if !tt.rateLimit {
+				if string(fields["api_rate_limit"]) != "null" {
+					t.Errorf("api_rate_limit = %s, want null", fields["api_rate_limit"])
+				}
+				return
+			}
+			var rateLimit map[string]json.RawMessage
+			if err := json.Unmarshal(fields["api_rate_limit"], &rateLimit); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"limit", "remaining", "reset", "updated_at"} {
+				if _, ok := rateLimit[key]; !ok {
+					t.Errorf("api_rate_limit missing %q: %s", key, fields["api_rate_limit"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"ghproxy/config"
+	"net/http"
+	"os"
+)
+
+// 上游TLS配置, 由 InitReq 加载, 为nil时使用默认配置
+var upstreamTLS *tls.Config
+
+// loadUpstreamTLS 按 config.Upstream.TLS 构建上游TLS配置, 未配置时返回nil
+func loadUpstreamTLS(cfg *config.Config) (*tls.Config, error) {
+	tlsCfg := cfg.Upstream.TLS
+	if tlsCfg.CACertFile == "" && !tlsCfg.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if tlsCfg.CACertFile != "" {
+		pem, err := os.ReadFile(tlsCfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA file: %w", err)
+		}
+		// 在系统根证书的基础上追加自定义CA
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in upstream CA file: %s", tlsCfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if tlsCfg.InsecureSkipVerify {
+		logWarning("Upstream TLS certificate verification is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// applyUpstreamTLS 为transport设置上游TLS配置
+func applyUpstreamTLS(transport *http.Transport) {
+	if upstreamTLS == nil {
+		return
+	}
+	transport.TLSClientConfig = upstreamTLS.Clone()
+	// 自定义TLSClientConfig时需显式启用HTTP/2
+	transport.ForceAttemptHTTP2 = true
+}
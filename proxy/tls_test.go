@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalidFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		caCertFile string
+		insecure   bool
+		wantErr    string // 非空时期望加载失败
+		wantStatus int
+	}{
+		{name: "system roots reject self-signed", wantStatus: 500},
+		{name: "custom CA", caCertFile: caFile, wantStatus: 200},
+		{name: "insecure skip verify", insecure: true, wantStatus: 200},
+		{name: "missing CA file", caCertFile: filepath.Join(dir, "missing.pem"), wantErr: "failed to read upstream CA file"},
+		{name: "invalid CA file", caCertFile: invalidFile, wantErr: "no valid certificates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Upstream.TLS.CACertFile = tt.caCertFile
+			cfg.Upstream.TLS.InsecureSkipVerify = tt.insecure
+			tlsConfig, err := loadUpstreamTLS(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadUpstreamTLS error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			upstreamTLS = tlsConfig
+			t.Cleanup(func() { upstreamTLS = nil })
+
+			c := newTestRequestContext(http.MethodGet)
+			if status := doChunkedProxy(t, cfg, c, server.URL+"/a.bin", MatcherRaw); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// upstreamErrHolder 记录transport层产生的哨兵错误(重定向、内网地址、请求体超限)
+// httpc 将 client.Do 返回的 *url.Error 均视为网络错误重试, 最终返回不包装原错误的 ErrMaxRetriesExceeded,
+// 因此需在错误被包装前记录, 并取消本次请求的context以跳过剩余的重试
+type upstreamErrHolder struct {
+	mu     sync.Mutex
+	err    error
+	cancel context.CancelFunc
+}
+
+type upstreamErrKey struct{}
+
+// withUpstreamErrHolder 为上游请求创建可记录哨兵错误的context
+// 请求体为 bodyLimitReader 时一并绑定, 使其超限时同样记录
+func withUpstreamErrHolder(ctx context.Context, c *app.RequestContext) (context.Context, *upstreamErrHolder) {
+	ctx, cancel := context.WithCancel(ctx)
+	holder := &upstreamErrHolder{cancel: cancel}
+	if reader, ok := c.Request.BodyStream().(*bodyLimitReader); ok {
+		reader.holder = holder
+	}
+	return context.WithValue(ctx, upstreamErrKey{}, holder), holder
+}
+
+// record 记录首个哨兵错误并取消请求
+func (h *upstreamErrHolder) record(err error) {
+	h.mu.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.mu.Unlock()
+	h.cancel()
+}
+
+// resolve 存在已记录的哨兵错误时返回该错误, 否则返回 client.Do 的原错误
+func (h *upstreamErrHolder) resolve(err error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return h.err
+	}
+	return err
+}
+
+// recordUpstreamError 将哨兵错误记录到ctx对应的holder, 返回原错误
+func recordUpstreamError(ctx context.Context, err error) error {
+	if holder, ok := ctx.Value(upstreamErrKey{}).(*upstreamErrHolder); ok {
+		holder.record(err)
+	}
+	return err
+}
+
+// upstreamErrorStatus 将上游请求的哨兵错误映射为返回给客户端的状态码, 非哨兵错误返回false
+func upstreamErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, errTooManyRedirects),
+		errors.Is(err, errRedirectHostNotAllowed),
+		errors.Is(err, errPrivateIP):
+		return 502, true
+	case errors.Is(err, errRequestBodyTooLarge):
+		return 413, true
+	}
+	return 0, false
+}
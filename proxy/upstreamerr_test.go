@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"ghproxy/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+func TestUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    func(hits *atomic.Int32, selfURL *string) http.HandlerFunc
+		setup      func(cfg *config.Config, c *app.RequestContext)
+		path       string
+		method     string
+		wantStatus int
+		maxHits    int32
+	}{
+		{
+			name: "redirect loop exceeds maxRedirects",
+			handler: func(hits *atomic.Int32, _ *string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					hits.Add(1)
+					http.Redirect(w, r, r.URL.Path, http.StatusFound)
+				}
+			},
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Upstream.MaxRedirects = 3
+			},
+			path:       "/loop",
+			method:     http.MethodGet,
+			wantStatus: 502,
+			maxHits:    4, // 首次请求与3次重定向, 不会重试
+		},
+		{
+			name: "redirect to host outside redirectAllowedHosts",
+			handler: func(hits *atomic.Int32, selfURL *string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					hits.Add(1)
+					http.Redirect(w, r, strings.Replace(*selfURL, "127.0.0.1", "localhost", 1)+"/off", http.StatusFound)
+				}
+			},
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Upstream.RedirectAllowedHosts = []string{"127.0.0.1"}
+			},
+			path:       "/redirect",
+			method:     http.MethodGet,
+			wantStatus: 502,
+			maxHits:    1,
+		},
+		{
+			name: "upstream resolves to loopback with blockPrivateIPs",
+			handler: func(hits *atomic.Int32, _ *string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					hits.Add(1)
+				}
+			},
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Upstream.BlockPrivateIPs = true
+			},
+			path:       "/private",
+			method:     http.MethodGet,
+			wantStatus: 502,
+			maxHits:    0,
+		},
+		{
+			name: "chunked request body exceeds maxRequestBodyBytes",
+			handler: func(hits *atomic.Int32, _ *string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					hits.Add(1)
+					_, _ = r.Body.Read(make([]byte, 1024))
+				}
+			},
+			setup: func(cfg *config.Config, c *app.RequestContext) {
+				cfg.Limits.MaxRequestBodyBytes = 8
+				c.Request.SetBodyStream(strings.NewReader(strings.Repeat("x", 64*1024)), -1)
+				if bodySizeCheck(cfg, c, MatcherAPI, "") {
+					t.Fatal("chunked body should not be rejected before forwarding")
+				}
+			},
+			path:       "/upload",
+			method:     http.MethodPost,
+			wantStatus: 413,
+			maxHits:    1,
+		},
+		{
+			name: "successful redirect within limits",
+			handler: func(hits *atomic.Int32, _ *string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					hits.Add(1)
+					if r.URL.Path == "/start" {
+						http.Redirect(w, r, "/end", http.StatusFound)
+						return
+					}
+					w.Write([]byte("ok"))
+				}
+			},
+			setup: func(cfg *config.Config, _ *app.RequestContext) {
+				cfg.Upstream.MaxRedirects = 3
+				cfg.Upstream.RedirectAllowedHosts = []string{"127.0.0.1"}
+			},
+			path:       "/start",
+			method:     http.MethodGet,
+			wantStatus: 200,
+			maxHits:    2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			var selfURL string
+			server := httptest.NewServer(tt.handler(&hits, &selfURL))
+			defer server.Close()
+			selfURL = server.URL
+
+			cfg := proxyTestConfig()
+			c := newTestRequestContext(tt.method)
+			tt.setup(cfg, c)
+
+			status := doChunkedProxy(t, cfg, c, server.URL+tt.path, MatcherAPI)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+			if got := hits.Load(); got > tt.maxHits {
+				t.Errorf("upstream hits = %d, want at most %d", got, tt.maxHits)
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		ok     bool
+	}{
+		{errTooManyRedirects, 502, true},
+		{errRedirectHostNotAllowed, 502, true},
+		{errPrivateIP, 502, true},
+		{errRequestBodyTooLarge, 413, true},
+		{context.Canceled, 0, false},
+	}
+	for _, tt := range tests {
+		status, ok := upstreamErrorStatus(tt.err)
+		if status != tt.status || ok != tt.ok {
+			t.Errorf("upstreamErrorStatus(%v) = %d, %v; want %d, %v", tt.err, status, ok, tt.status, tt.ok)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"ghproxy/auth"
 	"ghproxy/config"
 	"ghproxy/rate"
+	"io"
+	"strings"
 
 	"github.com/cloudwego/hertz/pkg/app"
 )
@@ -36,32 +39,225 @@ func listCheck(cfg *config.Config, c *app.RequestContext, user string, repo stri
 	return false
 }
 
-// 鉴权
-func authCheck(c *app.RequestContext, cfg *config.Config, matcher string, rawPath string) bool {
-	var err error
+// allowedRefsFor 按 owner/repo、owner/*、* 的顺序查找允许的ref列表, 未配置时返回false
+func allowedRefsFor(cfg *config.Config, user string, repo string) ([]string, bool) {
+	for _, key := range []string{user + "/" + repo, user + "/*", "*"} {
+		if refs, ok := cfg.Access.AllowedRefs[key]; ok {
+			return refs, true
+		}
+	}
+	return nil, false
+}
 
-	if matcher == "api" && !cfg.Auth.ForceAllowApi {
-		if cfg.Auth.Method != "header" || !cfg.Auth.Enabled {
-			ErrorPage(c, NewErrorWithStatusLookup(403, "Github API Req without AuthHeader is Not Allowed"))
-			logInfo("%s %s %s AuthHeader Unavailable", c.ClientIP(), c.Method(), rawPath)
+// refAllowed 判断ref是否在允许列表内, refs/heads/ 与 refs/tags/ 前缀不参与比较
+func refAllowed(ref string, allowed []string) bool {
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	for _, a := range allowed {
+		if strings.TrimPrefix(strings.TrimPrefix(a, "refs/heads/"), "refs/tags/") == ref {
 			return true
 		}
 	}
+	return false
+}
 
-	// 鉴权
-	if cfg.Auth.Enabled {
-		var authcheck bool
-		authcheck, err = auth.AuthHandler(c, cfg)
-		if !authcheck {
-			ErrorPage(c, NewErrorWithStatusLookup(401, fmt.Sprintf("Unauthorized: %v", err)))
-			logInfo("%s %s %s %s %s Auth-Error: %v", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), err)
-			return true
+// raw/blob/archive 的ref不在 access.allowedRefs 内时返回403
+func refCheck(cfg *config.Config, c *app.RequestContext, result *MatchResult, rawPath string) bool {
+	if len(cfg.Access.AllowedRefs) == 0 {
+		return false
+	}
+	if result.Matcher != MatcherRaw && result.Matcher != MatcherBlob && result.Format == "" {
+		return false
+	}
+	// user-images 等不属于仓库或未能提取ref的链接无法按ref限制
+	if result.Repo == "" || result.Ref == "" {
+		return false
+	}
+	allowed, ok := allowedRefsFor(cfg, result.User, result.Repo)
+	if !ok || refAllowed(result.Ref, allowed) {
+		return false
+	}
+	errInfo := NewErrorWithStatusLookup(403, fmt.Sprintf("Ref Blocked: %s/%s@%s", result.User, result.Repo, result.Ref))
+	auditReject(cfg, c, rawPath, result, errInfo)
+	ErrorPage(c, errInfo)
+	logInfo("%s %s %s %s %s Ref Blocked: %s/%s@%s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), result.User, result.Repo, result.Ref)
+	return true
+}
+
+// WebSocket升级请求无法代理, 直接返回501
+func upgradeCheck(c *app.RequestContext) bool {
+	if !strings.EqualFold(strings.TrimSpace(string(c.GetHeader("Upgrade"))), "websocket") {
+		return false
+	}
+	ErrorPage(c, NewErrorWithStatusLookup(501, "websocket not supported"))
+	logInfo("%s %s %s %s %s WebSocket upgrade rejected", c.ClientIP(), c.Method(), c.Path(), c.Request.Header.UserAgent(), c.Request.Header.GetProtocol())
+	return true
+}
+
+// 禁用的matcher直接返回403
+func disabledCheck(cfg *config.Config, c *app.RequestContext, matcher MatcherType, user, repo, rawPath string) bool {
+	if len(cfg.Disabled) == 0 || !matchString(string(matcher), cfg.Disabled) {
+		return false
+	}
+	errInfo := NewErrorWithStatusLookup(403, "matcher disabled")
+	auditReject(cfg, c, rawPath, &MatchResult{User: user, Repo: repo, Matcher: matcher}, errInfo)
+	ErrorPage(c, errInfo)
+	logInfo("%s %s %s %s %s Matcher-Disabled: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), matcher)
+	return true
+}
+
+// 请求头大小检查, 超过上限时返回431
+func headerSizeCheck(cfg *config.Config, c *app.RequestContext, rawPath string) bool {
+	limit := cfg.Limits.MaxRequestHeaderBytes
+	if limit <= 0 {
+		return false
+	}
+	size := 0
+	c.Request.Header.VisitAll(func(key, value []byte) {
+		size += len(key) + len(value) + 4 // ": " 与 "\r\n"
+	})
+	if size <= limit {
+		return false
+	}
+	ErrorPage(c, NewErrorWithStatusLookup(431, fmt.Sprintf("Request headers too large: %d bytes (limit %d)", size, limit)))
+	logWarning("%s %s %s %s %s Request-Header-Too-Large: %d", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), size)
+	return true
+}
+
+// errRequestBodyTooLarge 长度未知的请求体在转发过程中超过限制
+var errRequestBodyTooLarge = errors.New("request body too large")
+
+// requestBodyLimitFor 返回matcher的请求体大小上限, 0为不限制
+func requestBodyLimitFor(matcher MatcherType, cfg *config.Config) int64 {
+	if limit, ok := cfg.Limits.MatcherMaxRequestBodyBytes[string(matcher)]; ok {
+		return limit
+	}
+	return cfg.Limits.MaxRequestBodyBytes
+}
+
+// bodySizeCheck 声明的请求体长度超过限制时返回413
+// 长度未知(chunked)的请求体以限制读取的方式转发, 超过时由请求方法返回413
+func bodySizeCheck(cfg *config.Config, c *app.RequestContext, matcher MatcherType, rawPath string) bool {
+	limit := requestBodyLimitFor(matcher, cfg)
+	if limit <= 0 {
+		return false
+	}
+	size := int64(c.Request.Header.ContentLength())
+	if size < 0 {
+		if c.Request.IsBodyStream() {
+			c.Request.SetBodyStream(&bodyLimitReader{reader: c.Request.BodyStream(), limit: limit, remaining: limit}, -1)
+			return false
 		}
+		size = int64(len(c.Request.Body()))
+	}
+	if size <= limit {
+		return false
+	}
+	ErrorPage(c, NewErrorWithStatusLookup(413, fmt.Sprintf("Request body too large: %d bytes (limit %d)", size, limit)))
+	logWarning("%s %s %s %s %s Request-Body-Too-Large: %d", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), size)
+	return true
+}
+
+// bodyLimitReader 读取超过 limit 字节时返回 errRequestBodyTooLarge
+// 绑定holder后超限时一并记录并取消上游请求, 避免httpc以已读取部分的请求体重试
+type bodyLimitReader struct {
+	reader    io.Reader
+	limit     int64
+	remaining int64
+	holder    *upstreamErrHolder
+}
+
+func (r *bodyLimitReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, r.exceeded()
+	}
+	// 多读一个字节用于判断是否超过限制
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, r.exceeded()
+	}
+	return n, err
+}
+
+func (r *bodyLimitReader) exceeded() error {
+	err := fmt.Errorf("%w (limit %d)", errRequestBodyTooLarge, r.limit)
+	if r.holder != nil {
+		r.holder.record(err)
+	}
+	return err
+}
+
+// 鉴权
+func authCheck(c *app.RequestContext, cfg *config.Config, result *MatchResult, rawPath string) bool {
+	errInfo := getAuthorizer(cfg).Authorize(result, c)
+	if errInfo != nil {
+		auditReject(cfg, c, rawPath, result, errInfo)
+		ErrorPage(c, errInfo)
+		logInfo("%s %s %s %s %s Auth-Error: %s, Matched-Username: %s, Matched-Repo: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), errInfo.ErrorMessage, result.User, result.Repo)
+		return true
+	}
+
+	return false
+}
+
+// 自定义url校验
+func validateCheck(c *app.RequestContext, result *MatchResult, rawPath string) bool {
+	errInfo := urlValidator(result, result.Parsed)
+	if errInfo != nil {
+		ErrorPage(c, errInfo)
+		logInfo("%s %s %s %s %s Validate-Error: %s", c.ClientIP(), c.Method(), rawPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), errInfo.ErrorMessage)
+		return true
 	}
 
 	return false
 }
 
+// 对配置为重定向的matcher直接返回302, 不再中转流量
+func redirectCheck(cfg *config.Config, c *app.RequestContext, matcher MatcherType, rawPath string) bool {
+	if len(cfg.Shell.RedirectMatchers) == 0 || !matchString(string(matcher), cfg.Shell.RedirectMatchers) {
+		return false
+	}
+	// 省略scheme的路径需补全为绝对url, 否则 Location 会被解析为代理上的相对路径
+	location := rawPath
+	if !strings.HasPrefix(location, "https://") && !strings.HasPrefix(location, "http://") {
+		location = "https://" + location
+	}
+	c.Redirect(302, []byte(location))
+	logInfo("%s %s %s %s %s Redirect-Matcher: %s", c.ClientIP(), c.Method(), location, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), matcher)
+	return true
+}
+
+// canonicalCheck 请求路径与匹配得到的规范url不一致时(省略scheme、尾部斜杠、嵌套代理前缀、@ref形式等), 以308重定向至规范路径
+func canonicalCheck(cfg *config.Config, c *app.RequestContext, requestedPath string, result *MatchResult) bool {
+	if !cfg.Server.CanonicalRedirect {
+		return false
+	}
+	canonical := canonicalURL(result.URL)
+	if canonicalCompareForm(requestedPath) == canonicalCompareForm(canonical) {
+		return false
+	}
+	location := "/" + canonical
+	if prefix := strings.Trim(cfg.Server.PathPrefix, "/"); prefix != "" {
+		location = "/" + prefix + location
+	}
+	c.Header("Location", location)
+	c.Status(308)
+	logInfo("%s %s %s %s %s Canonical-Redirect: %s", c.ClientIP(), c.Method(), requestedPath, c.Request.Header.UserAgent(), c.Request.Header.GetProtocol(), location)
+	return true
+}
+
+// canonicalCompareForm 按请求路径的解析方式去除scheme及其后的斜杠, 用于比较请求路径与规范url
+// 前置代理合并斜杠(https:/github.com/...)、改写scheme或省略scheme均不改变上游url, 不应触发重定向, 否则会循环重定向
+func canonicalCompareForm(p string) string {
+	if matches := re.FindStringSubmatch(p); len(matches) == 3 {
+		p = matches[2]
+	}
+	return strings.TrimLeft(p, "/")
+}
+
 func rateCheck(cfg *config.Config, c *app.RequestContext, limiter *rate.RateLimiter, iplimiter *rate.IPRateLimiter) bool {
 	// 限制访问频率
 	if cfg.RateLimit.Enabled {
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+func TestCanonicalCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		wantLoc   string // 为空时不重定向
+	}{
+		{name: "canonical", requested: "https://github.com/user/repo/raw/main/a.sh"},
+		{name: "schemeless", requested: "github.com/user/repo/raw/main/a.sh"},
+		{name: "merged slashes", requested: "https:/github.com/user/repo/raw/main/a.sh"},
+		{name: "http scheme", requested: "http://github.com/user/repo/raw/main/a.sh"},
+		{name: "trailing slash", requested: "https://github.com/user/repo/tree/main/dir/", wantLoc: "/https://github.com/user/repo/tree/main/dir"},
+		{name: "at ref", requested: "https://raw.githubusercontent.com/user/repo@main/a.sh", wantLoc: "/https://raw.githubusercontent.com/user/repo/main/a.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Server.CanonicalRedirect = true
+			requestURL := "https://" + re.FindStringSubmatch(tt.requested)[2]
+			result, errInfo := matchRawPath(requestURL, cfg)
+			if errInfo != nil {
+				t.Fatalf("matchRawPath(%q): %v", requestURL, errInfo.ErrorMessage)
+			}
+
+			c := app.NewContext(0)
+			redirected := canonicalCheck(cfg, c, tt.requested, result)
+			location := string(c.Response.Header.Peek("Location"))
+			if tt.wantLoc == "" {
+				if redirected {
+					t.Fatalf("unexpected redirect to %q", location)
+				}
+				return
+			}
+			if !redirected || location != tt.wantLoc {
+				t.Fatalf("redirected = %v, Location = %q, want %q", redirected, location, tt.wantLoc)
+			}
+
+			// 跟随重定向后的路径不应再次重定向
+			followed := strings.TrimPrefix(location, "/")
+			result, errInfo = matchRawPath(followed, cfg)
+			if errInfo != nil {
+				t.Fatalf("matchRawPath(%q): %v", followed, errInfo.ErrorMessage)
+			}
+			if canonicalCheck(cfg, app.NewContext(0), followed, result) {
+				t.Errorf("redirect loop: %q redirected again", followed)
+			}
+		})
+	}
+}
+
+func TestRedirectCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawPath string
+		wantLoc string
+	}{
+		{name: "absolute", rawPath: "https://github.com/user/repo/releases/download/v1.0/app.zip", wantLoc: "https://github.com/user/repo/releases/download/v1.0/app.zip"},
+		{name: "schemeless", rawPath: "github.com/user/repo/releases/download/v1.0/app.zip", wantLoc: "https://github.com/user/repo/releases/download/v1.0/app.zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Shell.RedirectMatchers = []string{"releases"}
+			c := app.NewContext(0)
+			c.Request.SetRequestURI("/github.com/user/repo/releases/download/v1.0/app.zip")
+			if !redirectCheck(cfg, c, MatcherReleases, tt.rawPath) {
+				t.Fatal("redirectCheck did not redirect")
+			}
+			if location := string(c.Response.Header.Peek("Location")); location != tt.wantLoc {
+				t.Errorf("Location = %q, want %q", location, tt.wantLoc)
+			}
+		})
+	}
+}
+
+func TestRefCheck(t *testing.T) {
+	tests := []struct {
+		url         string
+		allowed     map[string][]string
+		wantBlocked bool
+	}{
+		{url: "https://raw.githubusercontent.com/user/repo/main/a.sh", allowed: map[string][]string{"user/repo": {"main"}}},
+		{url: "https://raw.githubusercontent.com/user/repo/refs/heads/main/a.sh", allowed: map[string][]string{"user/repo": {"main"}}},
+		{url: "https://github.com/user/repo/raw/refs/heads/main/a.sh", allowed: map[string][]string{"user/*": {"refs/heads/main"}}},
+		{url: "https://raw.githubusercontent.com/user/repo/refs/heads/dev/a.sh", allowed: map[string][]string{"user/repo": {"main"}}, wantBlocked: true},
+		{url: "https://github.com/user/repo/blob/dev/a.sh", allowed: map[string][]string{"*": {"main"}}, wantBlocked: true},
+		{url: "https://raw.githubusercontent.com/other/repo/dev/a.sh", allowed: map[string][]string{"user/repo": {"main"}}},
+		{url: "https://user-images.githubusercontent.com/12345/67890-abc.png", allowed: map[string][]string{"*": {"main"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Access.AllowedRefs = tt.allowed
+			result, errInfo := matchRawPath(tt.url, cfg)
+			if errInfo != nil {
+				t.Fatalf("matchRawPath(%q): %v", tt.url, errInfo.ErrorMessage)
+			}
+			c := app.NewContext(0)
+			if blocked := refCheck(cfg, c, result, tt.url); blocked != tt.wantBlocked {
+				t.Fatalf("refCheck(%q, ref %q) = %v, want %v", tt.url, result.Ref, blocked, tt.wantBlocked)
+			}
+			if tt.wantBlocked && c.Response.StatusCode() != 403 {
+				t.Errorf("status = %d, want 403", c.Response.StatusCode())
+			}
+		})
+	}
+}
+
+func TestUpgradeCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		upgrade     string
+		wantBlocked bool
+	}{
+		{name: "plain request"},
+		{name: "websocket", upgrade: "websocket", wantBlocked: true},
+		{name: "case and spaces", upgrade: " WebSocket ", wantBlocked: true},
+		{name: "h2c", upgrade: "h2c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := app.NewContext(0)
+			c.Request.SetRequestURI("/https://github.com/user/repo/raw/main/a.sh")
+			if tt.upgrade != "" {
+				c.Request.Header.Set("Connection", "Upgrade")
+				c.Request.Header.Set("Upgrade", tt.upgrade)
+			}
+			if blocked := upgradeCheck(c); blocked != tt.wantBlocked {
+				t.Fatalf("upgradeCheck = %v, want %v", blocked, tt.wantBlocked)
+			}
+			if !tt.wantBlocked {
+				return
+			}
+			if c.Response.StatusCode() != 501 {
+				t.Errorf("status = %d, want 501", c.Response.StatusCode())
+			}
+			if !strings.Contains(string(c.Response.Body()), "websocket not supported") {
+				t.Errorf("body = %q, want websocket not supported", c.Response.Body())
+			}
+		})
+	}
+}
+
+func TestHeaderSizeCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		cookieBytes int
+		wantBlocked bool
+	}{
+		{name: "disabled", limit: 0, cookieBytes: 64 * 1024},
+		{name: "within limit", limit: 1024, cookieBytes: 100},
+		{name: "exceeds limit", limit: 1024, cookieBytes: 2048, wantBlocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := proxyTestConfig()
+			cfg.Limits.MaxRequestHeaderBytes = tt.limit
+			c := newTestRequestContext("GET")
+			c.Request.Header.Set("User-Agent", "git/2.40.0")
+			c.Request.Header.Set("Cookie", strings.Repeat("a", tt.cookieBytes))
+			if blocked := headerSizeCheck(cfg, c, "https://github.com/user/repo.git/info/refs"); blocked != tt.wantBlocked {
+				t.Fatalf("headerSizeCheck = %v, want %v", blocked, tt.wantBlocked)
+			}
+			if tt.wantBlocked && c.Response.StatusCode() != 431 {
+				t.Errorf("status = %d, want 431", c.Response.StatusCode())
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"net/url"
+)
+
+// URLValidator 在Matcher完成分类后、请求上游前调用, 用于执行自定义策略(如屏蔽特定文件路径)
+// 返回nil表示放行; u 为解析后的上游url, 解析失败时为nil
+type URLValidator func(result *MatchResult, u *url.URL) *GHProxyErrors
+
+// 默认不做任何校验
+func noopURLValidator(result *MatchResult, u *url.URL) *GHProxyErrors {
+	return nil
+}
+
+var urlValidator URLValidator = noopURLValidator
+
+// SetURLValidator 注册自定义url校验, 传入nil恢复默认实现
+func SetURLValidator(v URLValidator) {
+	if v == nil {
+		v = noopURLValidator
+	}
+	urlValidator = v
+}
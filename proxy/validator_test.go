@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestURLValidator(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		wantStatus  int
+		wantMatcher MatcherType
+		wantURLPath string
+	}{
+		{name: "blocked file", path: "/https://github.com/user/repo/raw/main/.env", wantStatus: 403, wantMatcher: MatcherRaw, wantURLPath: "/user/repo/raw/main/.env"},
+		{name: "blocked org", path: "/https://raw.githubusercontent.com/blocked/repo/main/a.sh", wantStatus: 451, wantMatcher: MatcherRaw, wantURLPath: "/blocked/repo/main/a.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotResult *MatchResult
+				gotURL    *url.URL
+			)
+			SetURLValidator(func(result *MatchResult, u *url.URL) *GHProxyErrors {
+				gotResult, gotURL = result, u
+				if result.User == "blocked" {
+					return NewErrorWithStatusLookup(451, "organization blocked")
+				}
+				if u != nil && strings.HasSuffix(u.Path, "/.env") {
+					return NewErrorWithStatusLookup(403, "file blocked")
+				}
+				return nil
+			})
+			t.Cleanup(func() { SetURLValidator(nil) })
+			c := newTestRequestContext("GET")
+			c.Request.SetRequestURI(tt.path)
+
+			NoRouteHandler(proxyTestConfig(), nil, nil)(context.Background(), c)
+
+			if status := c.Response.StatusCode(); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", status, tt.wantStatus, c.Response.Body())
+			}
+			if gotResult == nil || gotResult.Matcher != tt.wantMatcher {
+				t.Fatalf("validator result = %+v, want matcher %q", gotResult, tt.wantMatcher)
+			}
+			if gotURL == nil || gotURL.Path != tt.wantURLPath {
+				t.Errorf("validator url = %v, want path %q", gotURL, tt.wantURLPath)
+			}
+		})
+	}
+}
+
+func TestSetURLValidatorNil(t *testing.T) {
+	SetURLValidator(nil)
+	if errInfo := urlValidator(&MatchResult{Matcher: MatcherRaw}, nil); errInfo != nil {
+		t.Errorf("default validator = %v, want nil", errInfo)
+	}
+}